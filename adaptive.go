@@ -0,0 +1,63 @@
+package retry
+
+import "time"
+
+// AdaptiveDelay 自适应的重试间隔策略, 按失败率的指数加权移动平均在minDelay和maxDelay之间调整时间间隔:
+// 失败集中出现时失败率升高、时间间隔变长, 恢复成功后失败率下降、时间间隔缩短.
+// 该策略会记录失败率, 可在依次执行的多个Do之间复用以延续学习结果, 但不能在多个goroutine中并发使用
+type AdaptiveDelay struct {
+	alpha    float64
+	minDelay time.Duration
+	maxDelay time.Duration
+	rate     float64
+}
+
+// NewAdaptiveDelay 创建自适应的重试间隔策略, 初始失败率为0.
+// alpha为平滑系数, 取值范围(0, 1], 越大越依赖最近的结果, 调整越快; minDelay和maxDelay分别为失败率为0和1时的时间间隔
+func NewAdaptiveDelay(alpha float64, minDelay, maxDelay time.Duration) *AdaptiveDelay {
+	alpha = min(max(alpha, 0), 1)
+	if maxDelay < minDelay {
+		maxDelay = minDelay
+	}
+	return &AdaptiveDelay{alpha: alpha, minDelay: minDelay, maxDelay: maxDelay}
+}
+
+// Delay 记录一次失败并计算时间间隔, 可作为DelayStrategy使用. err为nil时不记录, 只返回当前失败率对应的时间间隔,
+// 成功需通过Success记录
+func (a *AdaptiveDelay) Delay(n int, err error) time.Duration {
+	if err != nil {
+		a.fail()
+	}
+	return a.delay()
+}
+
+// Simulate 从当前失败率开始模拟连续times次失败, 返回每次失败后的时间间隔, 不修改当前失败率
+func (a *AdaptiveDelay) Simulate(times int) []time.Duration {
+	c := *a
+	delays := make([]time.Duration, 0, max(times, 0))
+	for n := 0; n < times; n++ {
+		c.fail()
+		delays = append(delays, c.delay())
+	}
+	return delays
+}
+
+// fail 记录一次失败
+func (a *AdaptiveDelay) fail() {
+	a.rate = a.alpha + (1-a.alpha)*a.rate
+}
+
+// delay 返回当前失败率对应的时间间隔
+func (a *AdaptiveDelay) delay() time.Duration {
+	return a.minDelay + time.Duration(float64(a.maxDelay-a.minDelay)*a.rate)
+}
+
+// Success 记录一次成功, 通常在OnSuccess中调用
+func (a *AdaptiveDelay) Success() {
+	a.rate = (1 - a.alpha) * a.rate
+}
+
+// Rate 返回当前失败率, 取值范围[0, 1]
+func (a *AdaptiveDelay) Rate() float64 {
+	return a.rate
+}
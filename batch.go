@@ -0,0 +1,129 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// DoAll 使用相同的配置分别执行fns中的每个函数并在失败时重试, 按fns的顺序返回每个函数最终的错误(成功时为nil).
+// 默认依次执行, 可通过WithConcurrency设置并发执行的数量, ctx结束后不再执行剩余的函数, 其错误为ctx.Err()
+func DoAll(ctx context.Context, fns []func() error, opts ...Option) []error {
+	ctxFns := make([]func(ctx context.Context) error, len(fns))
+	for i, fn := range fns {
+		if fn != nil {
+			fn := fn
+			ctxFns[i] = func(context.Context) error { return fn() }
+		}
+	}
+	return DoAllCtx(ctx, ctxFns, opts...)
+}
+
+// DoAllCtx 同DoAll, fns接收ctx以便在ctx结束时中断正在执行的函数
+func DoAllCtx(ctx context.Context, fns []func(ctx context.Context) error, opts ...Option) []error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	config := NewConfig(opts...)
+	errs := make([]error, len(fns))
+
+	if config.Concurrency == 0 || config.Concurrency == 1 {
+		for i, fn := range fns {
+			errs[i] = config.DoCtx(ctx, fn)
+		}
+		return errs
+	}
+
+	concurrency := config.Concurrency
+	if concurrency < 0 {
+		concurrency = len(fns)
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			for j := i; j < len(fns); j++ {
+				errs[j] = ctx.Err()
+			}
+			wg.Wait()
+			return errs
+		}
+		wg.Add(1)
+		go func(i int, fn func(ctx context.Context) error) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = config.DoCtx(ctx, fn)
+		}(i, fn)
+	}
+	wg.Wait()
+	return errs
+}
+
+// DoFirst 使用相同的配置依次执行fns中的函数并在失败时重试, 某个函数最终失败后执行下一个, 任一函数成功时返回nil,
+// 全部失败时返回由每个函数最终的错误通过errors.Join合并而成的错误. ctx结束后不再执行剩余的函数
+func DoFirst(ctx context.Context, fns []func() error, opts ...Option) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	config := NewConfig(opts...)
+	errs := make([]error, 0, len(fns))
+	for _, fn := range fns {
+		err := config.Do(ctx, fn)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DoRace 使用相同的配置并发执行parallelism份fn并分别在失败时重试, 返回最先成功的结果并取消传给其余fn的ctx,
+// 全部失败时返回由每份执行最终的错误通过errors.Join合并而成的错误. parallelism小于1时按1处理.
+// 返回时不等待其余的fn结束, fn需在ctx结束时尽快返回
+func DoRace[T any](ctx context.Context, fn func(ctx context.Context) (T, error), parallelism int, opts ...Option) (T, error) {
+	var zero T
+	if fn == nil {
+		return zero, ErrNilFunc
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	parallelism = max(parallelism, 1)
+	config := NewConfig(opts...)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		data T
+		err  error
+	}
+	results := make(chan result, parallelism)
+	for i := 0; i < parallelism; i++ {
+		go func() {
+			var data T
+			err := config.DoCtx(ctx, func(ctx context.Context) error {
+				var err error
+				data, err = fn(ctx)
+				return err
+			})
+			results <- result{data: data, err: err}
+		}()
+	}
+
+	errs := make([]error, 0, parallelism)
+	for i := 0; i < parallelism; i++ {
+		r := <-results
+		if r.err == nil {
+			return r.data, nil
+		}
+		errs = append(errs, r.err)
+	}
+	return zero, errors.Join(errs...)
+}
@@ -0,0 +1,62 @@
+package retry
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen 熔断器处于打开状态时返回
+var ErrCircuitOpen = errors.New("retry: circuit breaker is open")
+
+// CircuitBreaker 熔断器, 每次执行前调用Allow判断是否允许执行, 每次执行后调用Report上报执行结果
+type CircuitBreaker interface {
+	Allow() bool
+	Report(success bool)
+}
+
+// ConsecutiveFailureBreaker 基于连续失败次数的熔断器, 并发安全.
+// 连续失败达到failureThreshold次后打开, 打开openTimeout后允许一次试探执行, 试探成功则关闭, 失败则继续保持打开
+type ConsecutiveFailureBreaker struct {
+	mu               sync.Mutex
+	failureThreshold int
+	openTimeout      time.Duration
+	failures         int
+	openedAt         time.Time
+}
+
+func NewConsecutiveFailureBreaker(failureThreshold int, openTimeout time.Duration) *ConsecutiveFailureBreaker {
+	return &ConsecutiveFailureBreaker{
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+	}
+}
+
+func (b *ConsecutiveFailureBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.failureThreshold {
+		return true
+	}
+	if time.Since(b.openedAt) >= b.openTimeout {
+		// 半开状态, 仅允许一次试探执行
+		b.openedAt = time.Now()
+		return true
+	}
+	return false
+}
+
+func (b *ConsecutiveFailureBreaker) Report(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.failureThreshold {
+		b.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,87 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrAttemptLimit 共享的执行次数上限已用完, 首次执行前无法获取执行次数时返回
+var ErrAttemptLimit = errors.New("retry: shared attempt limit reached")
+
+// RetryBudget 基于令牌桶的重试预算, 可在多个Do之间共享以限制总体的重试速率, 并发安全.
+// 每次重试前消耗一个令牌, 令牌按refillRate随时间补充, 最多累积maxTokens个
+type RetryBudget struct {
+	mu         sync.Mutex
+	tokens     float64
+	maxTokens  float64
+	refillRate float64
+	last       time.Time
+}
+
+// NewRetryBudget 创建重试预算, 初始令牌数为maxTokens, refillRate为每秒补充的令牌数
+func NewRetryBudget(maxTokens int, refillRate float64) *RetryBudget {
+	return &RetryBudget{
+		tokens:     float64(maxTokens),
+		maxTokens:  float64(maxTokens),
+		refillRate: refillRate,
+		last:       time.Now(),
+	}
+}
+
+// Allow 尝试消耗一个令牌, 令牌不足时返回false
+func (b *RetryBudget) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.refillRate
+	if b.tokens > b.maxTokens {
+		b.tokens = b.maxTokens
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// RateLimiter 限流器, 每次执行前调用Wait等待, 与golang.org/x/time/rate.Limiter兼容
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+// AttemptCounter 共享的执行次数上限, 可在嵌套或并发的多个Do之间共享以限制fn的总执行次数, 并发安全.
+// 每次执行fn前获取一次执行次数, 获取与计数是原子的, 多个goroutine同时获取时总执行次数不会超过limit,
+// 但不保证获取的先后顺序; 已获取的次数不会归还
+type AttemptCounter struct {
+	limit int64
+	count atomic.Int64
+}
+
+// NewAttemptCounter 创建共享的执行次数上限, limit为所有共享该计数器的Do中fn的最大总执行次数
+func NewAttemptCounter(limit int) *AttemptCounter {
+	return &AttemptCounter{limit: int64(limit)}
+}
+
+// Acquire 尝试获取一次执行次数, 已达到上限时返回false
+func (c *AttemptCounter) Acquire() bool {
+	for {
+		count := c.count.Load()
+		if count >= c.limit {
+			return false
+		}
+		if c.count.CompareAndSwap(count, count+1) {
+			return true
+		}
+	}
+}
+
+// Count 返回已获取的执行次数
+func (c *AttemptCounter) Count() int {
+	return int(c.count.Load())
+}
@@ -0,0 +1,19 @@
+package retry
+
+import "time"
+
+// Clock 时钟, 用于在测试中替换真实时间
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,75 @@
+package retry
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// estimateMaxIterations EstimateAttempts逐次调用strategy的最大次数, 超过后按最后一次的时间间隔推算剩余的次数
+const estimateMaxIterations = 10000
+
+// EstimateAttempts 估算ctx的剩余时间内按strategy最多能执行的次数, 不计fn本身的耗时, 最多为maxTimes+1次.
+// strategy的err参数为nil, 返回StopDelay时停止估算. ctx已结束时返回0, 没有截止时间时返回maxTimes+1,
+// maxTimes为Infinite且无法确定上限时返回math.MaxInt. ctx为nil时视为context.Background().
+// 最多调用strategy 10000次, 之后按最后一次的时间间隔推算
+func EstimateAttempts(ctx context.Context, strategy DelayStrategy, maxTimes int) int {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if ctx.Err() != nil {
+		return 0
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		if maxTimes == Infinite {
+			return math.MaxInt
+		}
+		return maxTimes + 1
+	}
+	remaining := time.Until(deadline)
+	attempts := 1
+	var total, last time.Duration
+	for n := 0; maxTimes == Infinite || n < maxTimes; n++ {
+		if n == estimateMaxIterations {
+			if last <= 0 {
+				return maxTimes + 1
+			}
+			attempts += int((remaining - total) / last)
+			if maxTimes != Infinite {
+				attempts = min(attempts, maxTimes+1)
+			}
+			break
+		}
+		delay := strategy(n, nil)
+		if delay == StopDelay {
+			break
+		}
+		last = delay
+		if delay <= 0 && maxTimes == Infinite {
+			return math.MaxInt
+		}
+		if delay > 0 {
+			total += delay
+		}
+		if total > remaining || total < 0 {
+			break
+		}
+		attempts++
+	}
+	return attempts
+}
+
+// SimulateDelays 依次计算strategy在n=0..times-1时的时间间隔, 用于检查策略配置, err参数为nil, 返回StopDelay时停止计算.
+// 不受MinDelay和MaxDelay限制. 有状态的策略在n=0时会重置状态, 因此每次模拟互不影响, 但不能与正在使用该策略的Do并发调用
+func SimulateDelays(strategy DelayStrategy, times int) []time.Duration {
+	delays := make([]time.Duration, 0, max(times, 0))
+	for n := 0; n < times; n++ {
+		delay := strategy(n, nil)
+		if delay == StopDelay {
+			break
+		}
+		delays = append(delays, delay)
+	}
+	return delays
+}
@@ -0,0 +1,34 @@
+package retry
+
+import "time"
+
+// RetryEventType 重试事件类型
+type RetryEventType int
+
+const (
+	// EventFailed 第Attempt次执行失败, Delay为下次重试前的等待时间, 不再重试时为0
+	EventFailed RetryEventType = iota
+	// EventRetry 等待Delay后开始第Attempt次重试, Err为上次执行返回的错误
+	EventRetry
+)
+
+// RetryEvent 重试事件
+type RetryEvent struct {
+	Type    RetryEventType
+	Name    string
+	Attempt int
+	Err     error
+	Delay   time.Duration
+	Time    time.Time
+}
+
+// emit 以非阻塞的方式发送重试事件, 通道已满时丢弃
+func (config *Config) emit(event RetryEvent) {
+	if config.Events == nil {
+		return
+	}
+	select {
+	case config.Events <- event:
+	default:
+	}
+}
@@ -0,0 +1,46 @@
+package retry
+
+import (
+	"context"
+	"sync"
+)
+
+// Group 使用相同的配置并发执行多个函数并在失败时重试, 类似errgroup.Group, 任一函数最终失败时取消Group的ctx
+type Group struct {
+	config  *Config
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	wg      sync.WaitGroup
+	errOnce sync.Once
+	err     error
+}
+
+// NewGroup 创建Group, 返回的ctx在任一函数最终失败或Wait返回时取消, context.Cause为最先失败的错误
+func NewGroup(ctx context.Context, opts ...Option) (*Group, context.Context) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &Group{config: NewConfig(opts...), ctx: ctx, cancel: cancel}, ctx
+}
+
+// Go 在新的goroutine中执行fn并在失败时重试, 重试过程使用Group的ctx
+func (g *Group) Go(fn func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := g.config.Do(g.ctx, fn); err != nil {
+			g.errOnce.Do(func() {
+				g.err = err
+				g.cancel(err)
+			})
+		}
+	}()
+}
+
+// Wait 等待所有函数执行完成, 返回最先最终失败的函数的错误
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel(g.err)
+	return g.err
+}
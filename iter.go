@@ -0,0 +1,80 @@
+package retry
+
+import (
+	"context"
+	"iter"
+)
+
+// Attempt Attempts中的一次执行
+type Attempt struct {
+	ctx    context.Context
+	n      int
+	err    error
+	failed bool
+}
+
+// Number 返回当前的执行次数, 0表示首次执行
+func (a *Attempt) Number() int {
+	return a.n
+}
+
+// Context 返回本次执行使用的ctx, 设置了AttemptTimeout时为带超时的子context
+func (a *Attempt) Context() context.Context {
+	return a.ctx
+}
+
+// Success 标记本次执行成功, 迭代随之结束
+func (a *Attempt) Success() {
+	a.err, a.failed = nil, false
+}
+
+// Fail 标记本次执行失败, 按配置决定是否等待后继续迭代, err为nil时等同于Success
+func (a *Attempt) Fail(err error) {
+	a.err, a.failed = err, err != nil
+}
+
+// Attempts 以迭代器的方式执行重试, 每次迭代为一次执行, 循环体通过Attempt.Success或Attempt.Fail反馈执行结果:
+// 调用Success或未调用任何方法时结束迭代, 调用Fail时按配置判断是否重试, 需要重试时等待后进入下一次迭代.
+// 重试次数用尽、ctx结束或循环体中break时结束迭代, 可通过ctx.Err()判断是否因ctx结束
+func Attempts(ctx context.Context, opts ...Option) iter.Seq[*Attempt] {
+	return NewConfig(opts...).Attempts(ctx)
+}
+
+// Attempts 同Attempts
+func (config *Config) Attempts(ctx context.Context) iter.Seq[*Attempt] {
+	return func(yield func(*Attempt) bool) {
+		defer func() {
+			if r := recover(); r != nil {
+				if p, ok := r.(loopBodyPanic); ok {
+					r = p.value
+				}
+				panic(r)
+			}
+		}()
+		config.do(ctx, func(ctx context.Context) error {
+			attempt := &Attempt{ctx: ctx, n: AttemptFromContext(ctx)}
+			if !yieldAttempt(yield, attempt) {
+				return Break(attempt.err)
+			}
+			if attempt.failed {
+				return attempt.err
+			}
+			return nil
+		})
+	}
+}
+
+// loopBodyPanic 包装循环体中的panic, 使其不被WithRecover捕获而是原样抛出
+type loopBodyPanic struct {
+	value any
+}
+
+// yieldAttempt 执行循环体, 循环体panic时包装为loopBodyPanic再抛出
+func yieldAttempt(yield func(*Attempt) bool, attempt *Attempt) bool {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(loopBodyPanic{value: r})
+		}
+	}()
+	return yield(attempt)
+}
@@ -0,0 +1,24 @@
+package retry
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Logger 日志接口
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// SlogLogger 将slog.Logger转换为Logger, 日志以Info级别输出
+func SlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+func (s slogLogger) Printf(format string, args ...any) {
+	s.l.Info(fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,26 @@
+package retry
+
+import "time"
+
+// Metrics 重试指标, 可基于prometheus等指标库实现
+type Metrics interface {
+	// IncAttempt 每次执行fn前调用
+	IncAttempt()
+	// IncRetry 每次重试前调用
+	IncRetry()
+	// IncGiveUp 放弃重试并返回错误前调用
+	IncGiveUp()
+	// ObserveDelay 每次重试前等待时调用, d为等待时间
+	ObserveDelay(d time.Duration)
+}
+
+// NopMetrics 不做任何处理的Metrics, 可嵌入到自定义的实现中以只实现关心的方法
+type NopMetrics struct{}
+
+func (NopMetrics) IncAttempt() {}
+
+func (NopMetrics) IncRetry() {}
+
+func (NopMetrics) IncGiveUp() {}
+
+func (NopMetrics) ObserveDelay(d time.Duration) {}
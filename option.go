@@ -1,19 +1,34 @@
 package retry
 
 import (
+	"context"
+	"errors"
+	"math"
 	"math/rand"
 	"time"
 )
 
 type Option func(*Config)
 
-// WithTimes 重试次数, 默认为0表示不重试
+// WithName 设置重试策略的名称, 用于区分事件和日志, 设置后放弃重试时返回的错误会包装名称和执行次数
+func WithName(name string) Option {
+	return func(c *Config) {
+		c.Name = name
+	}
+}
+
+// WithTimes 重试次数, 默认为0表示不重试, 设置为Infinite表示无限重试
 func WithTimes(retryTimes int) Option {
 	return func(c *Config) {
 		c.RetryTimes = retryTimes
 	}
 }
 
+// WithInfiniteRetry 无限重试, 等同于WithTimes(Infinite)
+func WithInfiniteRetry() Option {
+	return WithTimes(Infinite)
+}
+
 // WithOnRetryFunc 仅在重试时执行, n代表开始第n次重试
 func WithOnRetryFunc(fn OnRetryFunc) Option {
 	return func(c *Config) {
@@ -28,10 +43,429 @@ func WithOnFailedFunc(fn OnFailedFunc) Option {
 	}
 }
 
+// WithOnRetryErr 同WithOnRetryFunc, fn返回错误时不再重试并返回该错误, 与WithOnRetryFunc同时设置时只执行fn
+func WithOnRetryErr(fn func(n int) error) Option {
+	return func(c *Config) {
+		c.OnRetryErr = fn
+	}
+}
+
+// WithOnFailedErr 同WithOnFailedFunc, fn返回错误时不再重试并返回该错误, 与WithOnFailedFunc同时设置时只执行fn
+func WithOnFailedErr(fn func(n int, err error) error) Option {
+	return func(c *Config) {
+		c.OnFailedErr = fn
+	}
+}
+
+// WithOnSuccessFunc 仅在执行成功时执行一次, n代表第n次重试(0表示首次调用)成功
+func WithOnSuccessFunc(fn OnSuccessFunc) Option {
+	return func(c *Config) {
+		c.OnSuccess = fn
+	}
+}
+
+// WithOnGiveUpFunc 仅在放弃重试并返回错误前执行一次(包括重试次数用尽、Break、RetryIf等提前中断), ctx结束时不执行
+func WithOnGiveUpFunc(fn OnGiveUpFunc) Option {
+	return func(c *Config) {
+		c.OnGiveUp = fn
+	}
+}
+
+// WithBeforeAttempt 每次执行fn前执行(包括首次), 返回错误时不执行fn并按执行失败处理
+func WithBeforeAttempt(fn BeforeAttemptFunc) Option {
+	return func(c *Config) {
+		c.BeforeAttempt = fn
+	}
+}
+
+// WithAfterAttempt 每次执行fn后执行(包括首次), 无论成功与否, BeforeAttempt返回错误时不执行
+func WithAfterAttempt(fn AfterAttemptFunc) Option {
+	return func(c *Config) {
+		c.AfterAttempt = fn
+	}
+}
+
+// WithOnAttemptDuration 每次执行fn后执行(包括首次), d为该次执行的耗时, 不包括重试等待时间, 设置了Clock时使用该时钟计时
+func WithOnAttemptDuration(fn OnAttemptDurationFunc) Option {
+	return func(c *Config) {
+		c.OnAttemptDuration = fn
+	}
+}
+
+// WithOnDelayInterrupted 仅在等待期间ctx结束或停止信号关闭时执行, remaining为未等待的剩余时间
+func WithOnDelayInterrupted(fn OnDelayInterruptedFunc) Option {
+	return func(c *Config) {
+		c.OnDelayInterrupted = fn
+	}
+}
+
+// WithOnContextCancelFunc 仅在ctx结束导致放弃重试时执行一次, 正常成功、重试次数用尽或停止信号关闭时不执行
+func WithOnContextCancelFunc(fn OnContextCancelFunc) Option {
+	return func(c *Config) {
+		c.OnContextCancel = fn
+	}
+}
+
 // WithDelayStrategy 设置下次重试时间间隔计算函数, 在报错时执行, n代表重试次数(0表示首次调用), err代表重试时产生的错误
 func WithDelayStrategy(delayType DelayStrategy) Option {
 	return func(c *Config) {
 		c.DelayStrategy = delayType
+		c.randDelayStrategy = nil
+	}
+}
+
+// WithDelayStrategyV2 设置下次重试时间间隔计算函数, 在报错时执行, 额外接收从开始执行到当前的耗时, 优先于WithDelayStrategy生效
+func WithDelayStrategyV2(delayType DelayStrategyV2) Option {
+	return func(c *Config) {
+		c.DelayStrategyV2 = delayType
+	}
+}
+
+// WithContextDelayStrategy 设置可感知ctx的重试间隔计算函数, 可根据ctx的截止时间等计算时间间隔, 优先于WithDelayStrategyV2和WithDelayStrategy生效
+func WithContextDelayStrategy(strategy ContextDelayStrategy) Option {
+	return func(c *Config) {
+		c.ContextDelayStrategy = strategy
+	}
+}
+
+// WithMaxDelay 设置重试时间间隔上限, 默认为0表示不限制, 对延迟策略的计算结果生效, 计算结果溢出为负数时同样取上限.
+// 与延迟策略自身的maxDelay同时生效, 以较小者为准
+func WithMaxDelay(maxDelay time.Duration) Option {
+	return func(c *Config) {
+		c.MaxDelay = maxDelay
+	}
+}
+
+// WithFirstRetryDelay 首次执行失败后等待d再进行第一次重试, 之后使用DelayStrategy计算, d仍受MinDelay和MaxDelay限制
+func WithFirstRetryDelay(d time.Duration) Option {
+	return func(c *Config) {
+		c.FirstRetryDelay = &d
+	}
+}
+
+// WithRandAwareDelayStrategy 设置实现了RandAware的重试间隔策略, 其随机数来源可通过WithRandSource统一设置
+func WithRandAwareDelayStrategy(strategy interface {
+	RandAware
+	Delay(n int, err error) time.Duration
+}) Option {
+	return func(c *Config) {
+		c.DelayStrategy = strategy.Delay
+		c.randDelayStrategy, _ = strategy.(*RandStrategy)
+		c.RandAware = append(c.RandAware, strategy)
+	}
+}
+
+// WithRandSource 为实现了RandAware的策略统一设置随机数来源, 未实现RandAware的策略仍使用各自的随机数来源.
+// rand.Rand不是并发安全的, 设置后配置不能在多个goroutine中并发使用
+func WithRandSource(r *rand.Rand) Option {
+	return func(c *Config) {
+		c.Rand = r
+	}
+}
+
+// WithErrorDelayOverride 执行失败的错误满足match时使用strategy计算重试间隔, 可多次设置, 按设置顺序使用第一个匹配的策略,
+// 均不匹配时使用WithDelayStrategy设置的策略
+func WithErrorDelayOverride(match func(err error) bool, strategy DelayStrategy) Option {
+	return func(c *Config) {
+		c.DelayOverrides = append(c.DelayOverrides, DelayOverride{Match: match, Strategy: strategy})
+	}
+}
+
+// WithPerErrorLimit 执行失败的错误满足match时最多重试limit次, 达到上限后不再重试并返回该错误, 可多次设置.
+// 按设置顺序使用第一个匹配的上限分别计数, 均不匹配的错误只受WithTimes限制, 所有错误的重试次数之和同样受WithTimes限制
+func WithPerErrorLimit(match func(err error) bool, limit int) Option {
+	return func(c *Config) {
+		c.PerErrorLimits = append(c.PerErrorLimits, PerErrorLimit{Match: match, Limit: limit})
+	}
+}
+
+// WithMinDelay 设置重试时间间隔下限, 默认为0, 对延迟策略的计算结果生效. 先应用下限再应用上限, 下限大于上限时以上限为准
+func WithMinDelay(minDelay time.Duration) Option {
+	return func(c *Config) {
+		c.MinDelay = minDelay
+	}
+}
+
+// WithInitialDelay 设置首次执行前的等待时间, 默认为0, 与延迟策略相互独立, 不计入重试次数
+func WithInitialDelay(initialDelay time.Duration) Option {
+	return func(c *Config) {
+		c.InitialDelay = initialDelay
+	}
+}
+
+// WithStartupJitter 首次执行前随机等待[0, max)的时间, 在InitialDelay之后等待, 不计入重试次数, 用于错开同时启动的多个实例.
+// 设置了WithRandSource时使用该随机数来源, 否则使用math/rand的全局随机数来源
+func WithStartupJitter(max time.Duration) Option {
+	return func(c *Config) {
+		c.StartupJitter = max
+	}
+}
+
+// WithSpinThreshold 小于d的重试等待使用自旋代替定时器, 以获得亚毫秒级的精确等待, 等待期间同样检查ctx和停止信号.
+// 自旋期间会持续占用CPU, 仅适用于等待时间很短且对精度敏感的场景; 设置了Clock时不生效
+func WithSpinThreshold(d time.Duration) Option {
+	return func(c *Config) {
+		c.SpinThreshold = d
+	}
+}
+
+// WithUntilConsecutiveSuccess 设置需要连续成功的次数, 默认在首次成功时结束, 执行失败时重新计数.
+// 未达到连续成功次数时按延迟策略等待后继续执行, 与失败重试共用重试次数, 用尽时返回ErrNotEnoughSuccesses.
+// 等待前与失败重试一样检查StopDelay、MaxElapsedTime、Budget和ContinueIf, 不满足时同样返回ErrNotEnoughSuccesses
+func WithUntilConsecutiveSuccess(count int) Option {
+	return func(c *Config) {
+		c.ConsecutiveSuccesses = count
+	}
+}
+
+// WithRetryIf 设置重试条件, 在报错时执行, 返回false时立即中断重试并返回该错误
+func WithRetryIf(fn RetryIfFunc) Option {
+	return func(c *Config) {
+		c.RetryIf = fn
+	}
+}
+
+// WithContinueIf 设置外部状态判断, 执行失败并调用OnFailed后、等待重试间隔前调用, 返回false时不再重试并返回该错误.
+// 与RetryIf不同, fn不检查错误, 适用于"功能是否仍然开启"等与外部状态相关的判断
+func WithContinueIf(fn func() bool) Option {
+	return func(c *Config) {
+		c.ContinueIf = fn
+	}
+}
+
+// WithClassifier 设置错误分类函数, 在一处同时决定是否重试和重试间隔.
+// 设置后RetryIf不再生效; 返回的delay优先于DelayStrategy、WithErrorDelayOverride和WithFirstRetryDelay,
+// 但仍受MinDelay、MaxDelay、MaxElapsedTime和Budget限制, 返回StopDelay时中断重试
+func WithClassifier(fn ClassifierFunc) Option {
+	return func(c *Config) {
+		c.Classifier = fn
+	}
+}
+
+// WithSuccessOn 执行返回的错误满足任一matcher时视为执行成功, 先于RetryIf和Break的判断, 可多次设置
+func WithSuccessOn(matchers ...func(err error) bool) Option {
+	return func(c *Config) {
+		c.SuccessOn = append(c.SuccessOn, matchers...)
+	}
+}
+
+// WithErrorTransform 转换每次执行返回的非nil错误, OnFailed、RetryIf、DelayStrategy和最终返回的都是转换后的错误,
+// 转换后为nil时视为执行成功. 转换先于Break的判断, 执行返回的Break错误会原样传入, 转换时也可以返回Break中断重试
+func WithErrorTransform(fn func(err error) error) Option {
+	return func(c *Config) {
+		c.ErrorTransform = fn
+	}
+}
+
+// WithRetryOnResult 配合DoWithData使用, fn返回nil错误但结果满足fn时也进行重试, 该次执行的错误为ErrRetryResult.
+// T需与DoWithData的类型参数一致, 否则不生效
+func WithRetryOnResult[T any](fn func(T) bool) Option {
+	return func(c *Config) {
+		c.RetryOnResult = fn
+	}
+}
+
+// WithAbortOnRepeatedError 连续threshold次执行返回的错误都匹配target(errors.Is)时不再重试并返回该错误, 不匹配的错误或执行成功时重新计数
+func WithAbortOnRepeatedError(target error, threshold int) Option {
+	return func(c *Config) {
+		c.RepeatedError = target
+		c.RepeatedErrorThreshold = threshold
+	}
+}
+
+// WithAbortIf 每次执行失败后调用fn, history为到目前为止每次执行失败的错误, 返回true时不再重试并返回该错误.
+// 设置后会保留每次执行失败的错误直到Do返回, 无限重试时内存占用随失败次数增长; fn不能修改或在返回后继续持有history
+func WithAbortIf(fn func(history []error) bool) Option {
+	return func(c *Config) {
+		c.AbortIf = fn
+	}
+}
+
+// WithMaxElapsedTime 设置重试总耗时上限, 默认为0表示不限制, 下次重试前的等待会超出上限时不再重试并返回最后一次的错误
+func WithMaxElapsedTime(maxElapsedTime time.Duration) Option {
+	return func(c *Config) {
+		c.MaxElapsedTime = maxElapsedTime
+	}
+}
+
+// WithRecover 捕获fn的panic并通过fn转换为错误, 按普通的执行失败处理, 默认不捕获
+func WithRecover(fn RecoverFunc) Option {
+	return func(c *Config) {
+		c.Recover = fn
+	}
+}
+
+// WithShouldRetryOnPanic 配合WithRecover使用, fn返回false时panic转换得到的错误标记为不可重试并直接返回, 先于RetryIf判断
+func WithShouldRetryOnPanic(fn func(r any) bool) Option {
+	return func(c *Config) {
+		c.ShouldRetryOnPanic = fn
+	}
+}
+
+// WithCombineErrors 重试失败时返回由每次执行的错误通过errors.Join合并而成的错误, 默认只返回最后一次执行的错误
+func WithCombineErrors() Option {
+	return func(c *Config) {
+		c.CombineErrors = true
+	}
+}
+
+// WithAttemptTimeout 设置每次执行的超时时间, 默认为0表示不限制, 仅对DoCtx生效, 超时按普通的执行失败处理
+func WithAttemptTimeout(attemptTimeout time.Duration) Option {
+	return func(c *Config) {
+		c.AttemptTimeout = attemptTimeout
+	}
+}
+
+// WithAttemptTimeoutFunc 同WithAttemptTimeout, 第n次执行的超时时间为fn(n), 为0时该次执行不限制, 设置后WithAttemptTimeout不再生效
+func WithAttemptTimeoutFunc(fn func(n int) time.Duration) Option {
+	return func(c *Config) {
+		c.AttemptTimeoutFunc = fn
+	}
+}
+
+// WithEvenlyBudgetedAttempts 按ctx的剩余时间平分每次执行的超时时间, 第n次执行的超时时间为剩余时间/剩余执行次数,
+// 每次执行前重新计算. 仅对DoCtx生效, ctx未设置截止时间或无限重试时不生效, 同时设置了AttemptTimeout时取较小者
+func WithEvenlyBudgetedAttempts() Option {
+	return func(c *Config) {
+		c.EvenlyBudgetedAttempts = true
+	}
+}
+
+// WithBudget 设置重试预算, 每次重试前从预算中获取令牌, 获取失败时不再重试并返回最后一次的错误
+func WithBudget(b *RetryBudget) Option {
+	return func(c *Config) {
+		c.Budget = b
+	}
+}
+
+// WithRateLimiter 设置限流器, 每次执行前(包括首次)调用limiter.Wait, 返回错误时不再执行并返回该错误,
+// 因ctx结束返回错误时与重试等待期间ctx结束的处理相同.
+// 与DelayStrategy同时设置时, 先按DelayStrategy等待, 再由限流器等待
+func WithRateLimiter(limiter RateLimiter) Option {
+	return func(c *Config) {
+		c.RateLimiter = limiter
+	}
+}
+
+// WithSharedAttemptLimit 设置共享的执行次数上限, 首次执行前和每次重试等待前从counter获取执行次数,
+// 获取失败时不再重试并返回最后一次的错误, 首次执行前获取失败时返回ErrAttemptLimit. 适用于嵌套重试, 避免执行次数成倍增长
+func WithSharedAttemptLimit(counter *AttemptCounter) Option {
+	return func(c *Config) {
+		c.AttemptCounter = counter
+	}
+}
+
+// WithCircuitBreaker 设置熔断器, 每次执行前调用cb.Allow, 不允许执行时立即返回ErrCircuitOpen, 每次执行后调用cb.Report上报执行结果
+func WithCircuitBreaker(cb CircuitBreaker) Option {
+	return func(c *Config) {
+		c.CircuitBreaker = cb
+	}
+}
+
+// WithEventChannel 设置重试事件通道, 每次执行失败和每次重试时以非阻塞的方式发送事件, 通道已满时丢弃事件.
+// 通道由调用方创建和关闭, 需保证Do返回前不关闭通道
+func WithEventChannel(ch chan<- RetryEvent) Option {
+	return func(c *Config) {
+		c.Events = ch
+	}
+}
+
+// WithLogger 设置日志, 每次执行失败时输出执行次数、错误和下次重试前的等待时间, 放弃重试时输出最终的错误, 为nil时不输出日志
+func WithLogger(l Logger) Option {
+	return func(c *Config) {
+		c.Logger = l
+	}
+}
+
+// WithMetrics 设置重试指标, 默认不记录
+func WithMetrics(m Metrics) Option {
+	return func(c *Config) {
+		c.Metrics = m
+	}
+}
+
+// WithTracer 设置链路追踪, 整个重试过程和每次执行分别创建span, 默认不追踪
+func WithTracer(t Tracer) Option {
+	return func(c *Config) {
+		c.Tracer = t
+	}
+}
+
+// WithClock 设置时钟, 默认使用真实时间, 可在测试中使用retrytest.FakeClock手动推进时间
+func WithClock(clock Clock) Option {
+	return func(c *Config) {
+		c.Clock = clock
+	}
+}
+
+// WithConcurrency 设置DoAll并发执行的数量, 默认为0表示依次执行, 为Infinite时不限制并发数量
+func WithConcurrency(n int) Option {
+	return func(c *Config) {
+		c.Concurrency = n
+	}
+}
+
+// WithPreserveLastError 重试过程中ctx结束时, 返回同时包装ctx.Err()和最后一次执行返回的错误的错误, 默认只返回ctx.Err()
+func WithPreserveLastError() Option {
+	return func(c *Config) {
+		c.PreserveLastError = true
+	}
+}
+
+// WithTrailingDelay 重试次数用尽后, 返回前按DelayStrategy再等待一次, 默认最后一次执行失败后立即返回.
+// 因Break、Unrecoverable、RetryIf等原因中断重试时不等待
+func WithTrailingDelay() Option {
+	return func(c *Config) {
+		c.TrailingDelay = true
+	}
+}
+
+// WithExhaustedError 重试次数用尽时使用fn生成最终返回的错误, 因Break、Unrecoverable、RetryIf、ctx结束等原因中断重试时不调用,
+// 默认返回最后一次执行返回的错误
+func WithExhaustedError(fn func(lastErr error, attempts int) error) Option {
+	return func(c *Config) {
+		c.ExhaustedError = fn
+	}
+}
+
+// WithHealthCheck 每次重试前调用probe探测依赖是否可用, 探测失败时等待后再次探测, 直到探测成功后才继续重试.
+// 探测失败后的等待时间从本次的重试间隔(不小于10ms)开始每次翻倍, 不超过MaxDelay(未设置时为1分钟).
+// 探测失败不计入fn的执行次数, 但一次Do中失败的探测次数超过重试次数或等待会超出MaxElapsedTime时放弃重试并返回最后一次的错误,
+// 等待期间ctx结束或停止信号关闭时立即结束
+func WithHealthCheck(probe func(ctx context.Context) error) Option {
+	return func(c *Config) {
+		c.HealthCheck = probe
+	}
+}
+
+// WithStopChannel 设置独立于ctx的停止信号, stop关闭后不再重试(正在等待时立即结束)并返回ErrStopped, 不影响正在执行的fn
+func WithStopChannel(stop <-chan struct{}) Option {
+	return func(c *Config) {
+		c.Stop = stop
+	}
+}
+
+// WithPauseChannel 设置暂停信号, 从pause接收到true后暂停重试, 接收到false或pause关闭后恢复.
+// 等待重试和执行fn期间接收信号, 处于暂停状态时等待结束后继续等待恢复再重试, 暂停期间ctx结束或停止信号关闭时立即结束.
+// Do返回后不再接收信号, 发送方需同时等待Do结束以免阻塞
+func WithPauseChannel(pause <-chan bool) Option {
+	return func(c *Config) {
+		c.Pause = pause
+	}
+}
+
+// WithTypedErrors 重试次数用尽时返回包装最后一次执行返回的错误的*RetriesExhaustedError, 可通过errors.Is(err, ErrRetriesExhausted)判断,
+// 默认返回最后一次执行返回的错误. 同时设置了WithExhaustedError时包装其返回的错误
+func WithTypedErrors() Option {
+	return func(c *Config) {
+		c.TypedErrors = true
+	}
+}
+
+// WithContextErrorWrapping 重试过程中ctx结束时返回*ContextError, 包含fn实际执行的次数和最后一次执行返回的错误, 默认只返回ctx.Err()
+func WithContextErrorWrapping() Option {
+	return func(c *Config) {
+		c.WrapContextError = true
 	}
 }
 
@@ -53,9 +487,52 @@ func LinearDelay(baseDelay, maxDelay time.Duration) DelayStrategy {
 	}
 }
 
+// LinearDelayUncapped 不设上限的线性时间间隔, 第n次的时间间隔为baseDelay*(n+1), 溢出时为time.Duration的最大值.
+// 通常与WithMaxDelay配合使用, 由全局上限代替各策略中的maxDelay
+func LinearDelayUncapped(baseDelay time.Duration) DelayStrategy {
+	return func(n int, err error) time.Duration {
+		if baseDelay <= 0 {
+			return 0
+		}
+		if int64(n+1) > math.MaxInt64/int64(baseDelay) {
+			return math.MaxInt64
+		}
+		return baseDelay * time.Duration(n+1)
+	}
+}
+
+// LinearDelayWithStep 线性时间间隔, 第n次的时间间隔为initial+step*n, 不超过maxDelay, initial与step相同时与LinearDelay相同
+func LinearDelayWithStep(initial, step, maxDelay time.Duration) DelayStrategy {
+	return func(n int, err error) time.Duration {
+		if step > 0 && time.Duration(n) > (maxDelay-initial)/step {
+			return maxDelay
+		}
+		delay := initial + step*time.Duration(n)
+		if delay > maxDelay || delay < 0 {
+			delay = maxDelay
+		}
+		return delay
+	}
+}
+
+// TaperedDelay 渐缓时间间隔, 从n=0时的baseDelay线性过渡到n=totalTimes时的maxDelay, 超出后为maxDelay,
+// totalTimes通常与WithTimes设置的重试次数相同, 不大于0时为maxDelay
+func TaperedDelay(baseDelay, maxDelay time.Duration, totalTimes int) DelayStrategy {
+	return func(n int, err error) time.Duration {
+		if totalTimes <= 0 || n >= totalTimes {
+			return maxDelay
+		}
+		return baseDelay + time.Duration(float64(maxDelay-baseDelay)*float64(n)/float64(totalTimes))
+	}
+}
+
 // ExponentialDelay 指数时间间隔
 func ExponentialDelay(baseDelay, maxDelay time.Duration) DelayStrategy {
 	return func(n int, err error) time.Duration {
+		// 移位溢出时高位会被丢弃, 结果可能为0或较小的正数, 需在移位前判断
+		if baseDelay > 0 && (n >= 63 || baseDelay > math.MaxInt64>>n) {
+			return maxDelay
+		}
 		delay := baseDelay << n
 		if delay > maxDelay || delay < 0 {
 			delay = maxDelay
@@ -64,8 +541,89 @@ func ExponentialDelay(baseDelay, maxDelay time.Duration) DelayStrategy {
 	}
 }
 
-// RandomDelay 随机时间间隔
+// ExponentialDelayUncapped 不设上限的指数时间间隔, 第n次的时间间隔为baseDelay*2^n, 溢出时为time.Duration的最大值.
+// 通常与WithMaxDelay配合使用, 由全局上限代替各策略中的maxDelay
+func ExponentialDelayUncapped(baseDelay time.Duration) DelayStrategy {
+	return func(n int, err error) time.Duration {
+		if baseDelay <= 0 {
+			return 0
+		}
+		if n >= 63 || baseDelay > math.MaxInt64>>n {
+			return math.MaxInt64
+		}
+		return baseDelay << n
+	}
+}
+
+// ExponentialDelayForBudget 按总时间预算计算的指数时间间隔, 前times次重试的时间间隔之和约为totalBudget.
+// 第n次的时间间隔为base*2^n, 其中base=totalBudget/(2^times-1), 各项向下取整到纳秒, 因此总和不超过totalBudget且误差小于times纳秒,
+// n不小于times时保持为最后一次的时间间隔, times不大于0时为0
+func ExponentialDelayForBudget(times int, totalBudget time.Duration) DelayStrategy {
+	return func(n int, err error) time.Duration {
+		if times <= 0 || totalBudget <= 0 {
+			return 0
+		}
+		if n >= times {
+			n = times - 1
+		}
+		// 2^n/(2^times-1) = 2^(n-times)/(1-2^-times), 避免times较大时溢出
+		ratio := math.Ldexp(1, n-times) / (1 - math.Ldexp(1, -times))
+		return time.Duration(math.Floor(float64(totalBudget) * ratio))
+	}
+}
+
+// ExponentialDelayWithReset 锯齿形的指数时间间隔, 计算出的时间间隔超出maxDelay时重新从baseDelay开始增长.
+// 该策略会记录当前的指数, 不能在多个goroutine中并发使用, 并发场景需为每次Do单独创建
+func ExponentialDelayWithReset(baseDelay, maxDelay time.Duration) DelayStrategy {
+	exp := 0
+	return func(n int, err error) time.Duration {
+		if n == 0 {
+			exp = 0
+		}
+		delay := baseDelay << exp
+		if delay > maxDelay || delay < 0 {
+			exp = 0
+			delay = baseDelay
+			if delay > maxDelay {
+				delay = maxDelay
+			}
+		}
+		exp++
+		return delay
+	}
+}
+
+// ExponentialDelayWithFactor 指数时间间隔, 第n次的时间间隔为baseDelay*factor^n, factor为2时与ExponentialDelay相同
+func ExponentialDelayWithFactor(baseDelay, maxDelay time.Duration, factor float64) DelayStrategy {
+	return func(n int, err error) time.Duration {
+		delay := float64(baseDelay) * math.Pow(factor, float64(n))
+		if math.IsNaN(delay) || delay > float64(maxDelay) {
+			return maxDelay
+		}
+		if delay < 0 {
+			return 0
+		}
+		return time.Duration(delay)
+	}
+}
+
+// ExponentialDelayWithJitter 带抖动的指数时间间隔, 在ExponentialDelay的基础上随机浮动±jitterFraction, 随机数来源可通过JitterWithSource指定
+func ExponentialDelayWithJitter(baseDelay, maxDelay time.Duration, jitterFraction float64) DelayStrategy {
+	return Jitter(ExponentialDelay(baseDelay, maxDelay), jitterFraction)
+}
+
+// RandomDelay 随机时间间隔, 使用math/rand的全局随机数来源
 func RandomDelay(minDelay, maxDelay time.Duration) DelayStrategy {
+	return randomDelay(minDelay, maxDelay, rand.Int63n)
+}
+
+// RandomDelayWithSource 同RandomDelay, 使用r作为随机数来源以避免全局锁竞争.
+// rand.Rand不是并发安全的, r不能与其他goroutine共享, 返回的策略也不能在多个goroutine中并发使用
+func RandomDelayWithSource(minDelay, maxDelay time.Duration, r *rand.Rand) DelayStrategy {
+	return randomDelay(minDelay, maxDelay, r.Int63n)
+}
+
+func randomDelay(minDelay, maxDelay time.Duration, int63n func(int64) int64) DelayStrategy {
 	if minDelay < 0 {
 		minDelay = 0
 	}
@@ -75,7 +633,277 @@ func RandomDelay(minDelay, maxDelay time.Duration) DelayStrategy {
 	return func(n int, err error) time.Duration {
 		delay := minDelay
 		if maxDelay > minDelay {
-			delay += time.Duration(rand.Int63n(int64(maxDelay - minDelay + 1)))
+			delay += time.Duration(int63n(int64(maxDelay - minDelay + 1)))
+		}
+		return delay
+	}
+}
+
+// FullJitter 全抖动, 在0到strategy计算出的时间间隔之间随机取值
+func FullJitter(strategy DelayStrategy) DelayStrategy {
+	return fullJitter(strategy, rand.Int63n)
+}
+
+// FullJitterWithSource 同FullJitter, 使用r作为随机数来源
+func FullJitterWithSource(strategy DelayStrategy, r *rand.Rand) DelayStrategy {
+	return fullJitter(strategy, r.Int63n)
+}
+
+func fullJitter(strategy DelayStrategy, int63n func(int64) int64) DelayStrategy {
+	return func(n int, err error) time.Duration {
+		delay := strategy(n, err)
+		if delay == StopDelay {
+			return StopDelay
+		}
+		if delay <= 0 {
+			return 0
+		}
+		return time.Duration(int63n(int64(delay)))
+	}
+}
+
+// Jitter 抖动, 在strategy计算出的时间间隔上随机浮动±jitterFraction, 例如0.2表示±20%, 不会小于0
+func Jitter(strategy DelayStrategy, jitterFraction float64) DelayStrategy {
+	return jitter(strategy, jitterFraction, rand.Float64)
+}
+
+// JitterWithSource 同Jitter, 使用r作为随机数来源
+func JitterWithSource(strategy DelayStrategy, jitterFraction float64, r *rand.Rand) DelayStrategy {
+	return jitter(strategy, jitterFraction, r.Float64)
+}
+
+func jitter(strategy DelayStrategy, jitterFraction float64, float64n func() float64) DelayStrategy {
+	return func(n int, err error) time.Duration {
+		delay := strategy(n, err)
+		if delay <= 0 || jitterFraction <= 0 {
+			return delay
+		}
+		jittered := float64(delay) * (1 + jitterFraction*(2*float64n()-1))
+		if jittered < 0 {
+			return 0
+		}
+		if jittered >= math.MaxInt64 {
+			return delay
+		}
+		return time.Duration(jittered)
+	}
+}
+
+// WeightedStrategy WeightedDelay中的策略及其权重
+type WeightedStrategy struct {
+	Strategy DelayStrategy
+	Weight   float64
+}
+
+// WeightedDelay 每次按权重随机选择entries中的一个策略计算时间间隔, 权重不大于0的策略不会被选中, 没有可选的策略时为0
+func WeightedDelay(entries ...WeightedStrategy) DelayStrategy {
+	return weightedDelay(rand.Float64, entries)
+}
+
+// WeightedDelayWithSource 同WeightedDelay, 使用r作为随机数来源
+func WeightedDelayWithSource(r *rand.Rand, entries ...WeightedStrategy) DelayStrategy {
+	return weightedDelay(r.Float64, entries)
+}
+
+func weightedDelay(float64n func() float64, entries []WeightedStrategy) DelayStrategy {
+	entries = append([]WeightedStrategy(nil), entries...)
+	var total float64
+	for _, entry := range entries {
+		if entry.Weight > 0 {
+			total += entry.Weight
+		}
+	}
+	return func(n int, err error) time.Duration {
+		if total <= 0 {
+			return 0
+		}
+		target := float64n() * total
+		var selected DelayStrategy
+		for _, entry := range entries {
+			if entry.Weight <= 0 {
+				continue
+			}
+			selected = entry.Strategy
+			if target < entry.Weight {
+				break
+			}
+			target -= entry.Weight
+		}
+		return selected(n, err)
+	}
+}
+
+// DecorrelatedJitterDelay 去相关抖动时间间隔, 在baseDelay到上次时间间隔的3倍之间随机取值, 不超过maxDelay.
+// 该策略会记录上次的时间间隔, 不能在多个goroutine中并发使用, 并发场景需为每次Do单独创建
+func DecorrelatedJitterDelay(baseDelay, maxDelay time.Duration) DelayStrategy {
+	prev := baseDelay
+	return func(n int, err error) time.Duration {
+		if n == 0 {
+			prev = baseDelay
+		}
+		upper := prev * 3
+		if upper > maxDelay || upper < 0 {
+			upper = maxDelay
+		}
+		delay := baseDelay
+		if upper > baseDelay {
+			delay += time.Duration(rand.Int63n(int64(upper - baseDelay + 1)))
+		}
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+		prev = delay
+		return delay
+	}
+}
+
+// FibonacciDelay 斐波那契时间间隔, 第n次的时间间隔为baseDelay*fib(n), fib(0)=fib(1)=1
+func FibonacciDelay(baseDelay, maxDelay time.Duration) DelayStrategy {
+	return func(n int, err error) time.Duration {
+		delay, next := baseDelay, baseDelay
+		for i := 0; i < n && delay <= maxDelay && delay >= 0; i++ {
+			delay, next = next, delay+next
+		}
+		if delay > maxDelay || delay < 0 {
+			delay = maxDelay
+		}
+		return delay
+	}
+}
+
+// FibonacciDelayWithJitter 带抖动的斐波那契时间间隔, 在FibonacciDelay的基础上随机浮动±jitterFraction, 随机数来源可通过JitterWithSource指定
+func FibonacciDelayWithJitter(baseDelay, maxDelay time.Duration, jitterFraction float64) DelayStrategy {
+	return Jitter(FibonacciDelay(baseDelay, maxDelay), jitterFraction)
+}
+
+// FibonacciDelayWithReset 锯齿形的斐波那契时间间隔, 计算出的时间间隔超出maxDelay时重新从baseDelay开始增长.
+// 该策略会记录当前的斐波那契数, 不能在多个goroutine中并发使用, 并发场景需为每次Do单独创建
+func FibonacciDelayWithReset(baseDelay, maxDelay time.Duration) DelayStrategy {
+	delay, next := baseDelay, baseDelay
+	return func(n int, err error) time.Duration {
+		if n == 0 {
+			delay, next = baseDelay, baseDelay
+		}
+		if delay > maxDelay || delay < 0 {
+			delay, next = baseDelay, baseDelay
+		}
+		current := delay
+		delay, next = next, delay+next
+		if current > maxDelay {
+			current = maxDelay
+		}
+		return current
+	}
+}
+
+// ScheduleDelay 按指定的时间表计算时间间隔, 第n次的时间间隔为delays[n], 超出时间表长度时取最后一个, delays为空时为0
+func ScheduleDelay(delays ...time.Duration) DelayStrategy {
+	delays = append([]time.Duration(nil), delays...)
+	return func(n int, err error) time.Duration {
+		if len(delays) == 0 {
+			return 0
+		}
+		if n >= len(delays) {
+			n = len(delays) - 1
+		}
+		return delays[n]
+	}
+}
+
+// RetryAfterDelay 当前错误(或其包装的错误)实现了RetryAfter() time.Duration时, 使用其返回值作为时间间隔, 否则使用fallback计算.
+// 适用于HTTP响应中的Retry-After
+func RetryAfterDelay(fallback DelayStrategy) DelayStrategy {
+	return func(n int, err error) time.Duration {
+		var retryAfterErr interface{ RetryAfter() time.Duration }
+		if errors.As(err, &retryAfterErr) {
+			return retryAfterErr.RetryAfter()
+		}
+		return fallback(n, err)
+	}
+}
+
+// UntilDelay 等待到target返回的时间点, 适用于服务端返回绝对重试时间的场景, 时间点已过时为0
+func UntilDelay(target func(err error) time.Time) DelayStrategy {
+	return UntilDelayWithClock(realClock{}, target)
+}
+
+// UntilDelayWithClock 同UntilDelay, 使用clock获取当前时间, 便于与WithClock配合测试
+func UntilDelayWithClock(clock Clock, target func(err error) time.Time) DelayStrategy {
+	return func(n int, err error) time.Duration {
+		delay := target(err).Sub(clock.Now())
+		if delay < 0 {
+			return 0
+		}
+		return delay
+	}
+}
+
+// MaxDelayStrategy 取a和b计算出的时间间隔中的较大者, 任一策略返回StopDelay时返回StopDelay
+func MaxDelayStrategy(a, b DelayStrategy) DelayStrategy {
+	return func(n int, err error) time.Duration {
+		delayA, delayB := a(n, err), b(n, err)
+		if delayA == StopDelay || delayB == StopDelay {
+			return StopDelay
+		}
+		if delayA > delayB {
+			return delayA
+		}
+		return delayB
+	}
+}
+
+// SumDelayStrategy 取strategies计算出的时间间隔之和, 溢出时取最大值, 任一策略返回StopDelay时返回StopDelay
+func SumDelayStrategy(strategies ...DelayStrategy) DelayStrategy {
+	return func(n int, err error) time.Duration {
+		var sum time.Duration
+		for _, strategy := range strategies {
+			delay := strategy(n, err)
+			if delay == StopDelay {
+				return StopDelay
+			}
+			if delay > 0 && sum > math.MaxInt64-delay {
+				sum = math.MaxInt64
+				continue
+			}
+			sum += delay
+		}
+		return sum
+	}
+}
+
+// SeverityScaledDelay 将base计算出的时间间隔乘以scale(err), 用于按错误的严重程度调整时间间隔, 结果不小于0, 溢出时取最大值
+func SeverityScaledDelay(base DelayStrategy, scale func(err error) float64) DelayStrategy {
+	return func(n int, err error) time.Duration {
+		delay := base(n, err)
+		if delay == StopDelay {
+			return StopDelay
+		}
+		scaled := float64(delay) * scale(err)
+		if math.IsNaN(scaled) || scaled < 0 {
+			return 0
+		}
+		if scaled >= math.MaxInt64 {
+			return math.MaxInt64
+		}
+		return time.Duration(scaled)
+	}
+}
+
+// CappedByDeadline 按ctx的剩余时间限制strategy计算出的时间间隔, 保证等待后至少剩余reserve用于下次执行,
+// 剩余时间不足reserve时返回StopDelay. 需为每次Do使用对应的ctx单独创建
+func CappedByDeadline(ctx context.Context, strategy DelayStrategy, reserve time.Duration) DelayStrategy {
+	return func(n int, err error) time.Duration {
+		delay := strategy(n, err)
+		deadline, ok := ctx.Deadline()
+		if !ok || delay == StopDelay {
+			return delay
+		}
+		available := time.Until(deadline) - reserve
+		if available <= 0 {
+			return StopDelay
+		}
+		if delay > available {
+			delay = available
 		}
 		return delay
 	}
@@ -0,0 +1,57 @@
+package retry
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RandAware 使用随机数的策略可实现该接口, 通过WithRandAwareDelayStrategy设置后, 由WithRandSource统一设置随机数来源
+type RandAware interface {
+	SetRand(r *rand.Rand)
+}
+
+// RandStrategy 可统一设置随机数来源的重试间隔策略, 实现RandAware
+type RandStrategy struct {
+	mu       sync.RWMutex
+	build    func(r *rand.Rand) DelayStrategy
+	strategy DelayStrategy
+}
+
+// NewRandStrategy 使用build创建RandStrategy, 未设置随机数来源时使用math/rand的全局随机数来源, 例如:
+//
+//	NewRandStrategy(func(r *rand.Rand) DelayStrategy { return FullJitterWithSource(ExponentialDelay(base, max), r) })
+func NewRandStrategy(build func(r *rand.Rand) DelayStrategy) *RandStrategy {
+	return &RandStrategy{build: build, strategy: build(rand.New(globalSource{}))}
+}
+
+// SetRand 使用r重新创建策略, rand.Rand不是并发安全的, 设置后策略不能在多个goroutine中并发使用
+func (s *RandStrategy) SetRand(r *rand.Rand) {
+	strategy := s.build(r)
+	s.mu.Lock()
+	s.strategy = strategy
+	s.mu.Unlock()
+}
+
+// clone 使用build重新创建策略, r为nil时使用math/rand的全局随机数来源
+func (s *RandStrategy) clone(r *rand.Rand) *RandStrategy {
+	if r == nil {
+		return NewRandStrategy(s.build)
+	}
+	return &RandStrategy{build: s.build, strategy: s.build(r)}
+}
+
+// Delay 计算第n次执行失败后的时间间隔
+func (s *RandStrategy) Delay(n int, err error) time.Duration {
+	s.mu.RLock()
+	strategy := s.strategy
+	s.mu.RUnlock()
+	return strategy(n, err)
+}
+
+// globalSource 使用math/rand全局随机数来源的rand.Source, 并发安全
+type globalSource struct{}
+
+func (globalSource) Int63() int64    { return rand.Int63() }
+func (globalSource) Uint64() uint64  { return rand.Uint64() }
+func (globalSource) Seed(seed int64) {}
@@ -2,6 +2,11 @@ package retry
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
 	"time"
 )
 
@@ -11,86 +16,1198 @@ type OnRetryFunc func(n int)
 // OnFailedFunc 执行失败回调, 第n次执行失败后调用(n=0时会调用)
 type OnFailedFunc func(n int, err error)
 
+// OnSuccessFunc 执行成功回调, 第n次执行成功后调用(n=0时会调用)
+type OnSuccessFunc func(n int)
+
+// OnGiveUpFunc 放弃重试回调, 返回错误前调用一次, attempts为fn实际执行的次数, err为最终返回的错误
+type OnGiveUpFunc func(attempts int, err error)
+
+// BeforeAttemptFunc 每次执行前回调, 第n次执行fn前调用(n=0时会调用), 返回错误时不执行fn并按执行失败处理
+type BeforeAttemptFunc func(n int) error
+
+// AfterAttemptFunc 每次执行后回调, 第n次执行fn后调用(n=0时会调用), 无论成功与否
+type AfterAttemptFunc func(n int, err error)
+
+// OnDelayInterruptedFunc 等待重试期间ctx结束或停止信号关闭时回调, remaining为未等待的剩余时间
+type OnDelayInterruptedFunc func(remaining time.Duration)
+
+// OnContextCancelFunc ctx结束导致放弃重试时回调, n为fn实际执行的次数, lastErr为最后一次执行fn返回的错误
+type OnContextCancelFunc func(n int, lastErr error)
+
+// OnAttemptDurationFunc 每次执行后回调, d为第n次执行fn的耗时, 不包括重试等待时间, err为fn返回的错误
+type OnAttemptDurationFunc func(n int, d time.Duration, err error)
+
+// RecoverFunc 执行panic时调用, 将recover得到的r转换为错误
+type RecoverFunc func(r any) error
+
 // DelayStrategy 重试间隔策略, 第n次执行失败后调用(n=0时会调用)
 type DelayStrategy func(n int, err error) time.Duration
 
+// StopDelay 延迟策略返回该值时不再重试, 并返回最后一次执行返回的错误
+const StopDelay time.Duration = math.MinInt64
+
+// DelayStrategyV2 重试间隔策略, 第n次执行失败后调用(n=0时会调用), elapsed为从开始执行到当前的耗时
+type DelayStrategyV2 func(n int, elapsed time.Duration, err error) time.Duration
+
+// ContextDelayStrategy 可感知ctx的重试间隔策略, 第n次执行失败后调用(n=0时会调用), ctx为传给Do的ctx
+type ContextDelayStrategy func(ctx context.Context, n int, err error) time.Duration
+
+// DelayOverride 错误满足Match时使用Strategy计算重试间隔
+type DelayOverride struct {
+	Match    func(err error) bool
+	Strategy DelayStrategy
+}
+
+// PerErrorLimit 错误满足Match时最多重试Limit次
+type PerErrorLimit struct {
+	Match func(err error) bool
+	Limit int
+}
+
+// RetryIfFunc 重试条件判断, 第n次执行失败后调用, 返回false时不再重试
+type RetryIfFunc func(err error) bool
+
+// ClassifierFunc 错误分类, 执行失败后调用, 同时决定是否重试以及重试间隔.
+// retry为false时不再重试; useDelay为true时使用delay作为本次重试间隔, 否则仍由DelayStrategy计算
+type ClassifierFunc func(err error) (retry bool, delay time.Duration, useDelay bool)
+
 type Config struct {
-	RetryTimes    int
-	OnRetry       OnRetryFunc
-	OnFailed      OnFailedFunc
-	DelayStrategy DelayStrategy
+	Name                   string
+	RetryTimes             int
+	OnRetry                OnRetryFunc
+	OnFailed               OnFailedFunc
+	OnRetryErr             func(n int) error
+	OnFailedErr            func(n int, err error) error
+	OnSuccess              OnSuccessFunc
+	OnGiveUp               OnGiveUpFunc
+	BeforeAttempt          BeforeAttemptFunc
+	AfterAttempt           AfterAttemptFunc
+	OnAttemptDuration      OnAttemptDurationFunc
+	OnDelayInterrupted     OnDelayInterruptedFunc
+	OnContextCancel        OnContextCancelFunc
+	DelayStrategy          DelayStrategy
+	DelayStrategyV2        DelayStrategyV2
+	ContextDelayStrategy   ContextDelayStrategy
+	DelayOverrides         []DelayOverride
+	FirstRetryDelay        *time.Duration
+	RetryIf                RetryIfFunc
+	Classifier             ClassifierFunc
+	ContinueIf             func() bool
+	ErrorTransform         func(err error) error
+	SuccessOn              []func(err error) bool
+	Recover                RecoverFunc
+	ShouldRetryOnPanic     func(r any) bool
+	CombineErrors          bool
+	AttemptTimeout         time.Duration
+	AttemptTimeoutFunc     func(n int) time.Duration
+	EvenlyBudgetedAttempts bool
+	MaxElapsedTime         time.Duration
+	MinDelay               time.Duration
+	MaxDelay               time.Duration
+	Budget                 *RetryBudget
+	AttemptCounter         *AttemptCounter
+	CircuitBreaker         CircuitBreaker
+	Concurrency            int
+	PreserveLastError      bool
+	WrapContextError       bool
+	TrailingDelay          bool
+	ExhaustedError         func(lastErr error, attempts int) error
+	TypedErrors            bool
+	HealthCheck            func(ctx context.Context) error
+	RetryOnResult          any
+	RepeatedError          error
+	RepeatedErrorThreshold int
+	PerErrorLimits         []PerErrorLimit
+	AbortIf                func(history []error) bool
+	Tracer                 Tracer
+	Stop                   <-chan struct{}
+	Pause                  <-chan bool
+	RateLimiter            RateLimiter
+	Events                 chan<- RetryEvent
+	Logger                 Logger
+	InitialDelay           time.Duration
+	StartupJitter          time.Duration
+	SpinThreshold          time.Duration
+	ConsecutiveSuccesses   int
+	Metrics                Metrics
+	Clock                  Clock
+	Rand                   *rand.Rand
+	RandAware              []RandAware
+	// randDelayStrategy DelayStrategy为WithRandAwareDelayStrategy设置的RandStrategy时, Clone重新创建后需同时更新DelayStrategy
+	randDelayStrategy *RandStrategy
 }
 
+// NewConfig 创建配置, 不校验配置是否合法. 设置了Rand时为RandAware中的策略设置随机数来源
 func NewConfig(opts ...Option) *Config {
 	config := Config{}
 	for _, opt := range opts {
 		opt(&config)
 	}
+	config.setRand()
 	return &config
 }
 
-type breakError struct {
-	error
+// Clone 复制配置, 修改副本不会影响原配置. 策略和回调函数本身不会被复制,
+// DecorrelatedJitterDelay等有状态的策略需在副本中通过With重新设置. RandAware中的RandStrategy会重新创建, 不与原配置共用
+func (config *Config) Clone() *Config {
+	clone := *config
+	clone.DelayOverrides = append([]DelayOverride(nil), config.DelayOverrides...)
+	clone.SuccessOn = append([]func(err error) bool(nil), config.SuccessOn...)
+	clone.PerErrorLimits = append([]PerErrorLimit(nil), config.PerErrorLimits...)
+	clone.RandAware = append([]RandAware(nil), config.RandAware...)
+	for i, randAware := range clone.RandAware {
+		strategy, ok := randAware.(*RandStrategy)
+		if !ok {
+			continue
+		}
+		cloned := strategy.clone(config.Rand)
+		clone.RandAware[i] = cloned
+		if strategy == config.randDelayStrategy {
+			clone.randDelayStrategy = cloned
+			clone.DelayStrategy = cloned.Delay
+		}
+	}
+	return &clone
+}
+
+// With 复制配置并应用opts, 返回新的配置, 原配置不受影响.
+// 继承的RandStrategy已在复制时重新创建, 可通过WithRandSource设置新的随机数来源; 其他RandAware实现与原配置共用, 不会重新设置随机数来源
+func (config *Config) With(opts ...Option) *Config {
+	clone := config.Clone()
+	for _, opt := range opts {
+		opt(clone)
+	}
+	if clone.Rand != nil {
+		for i, randAware := range clone.RandAware {
+			if _, ok := randAware.(*RandStrategy); ok || i >= len(config.RandAware) {
+				randAware.SetRand(clone.Rand)
+			}
+		}
+	}
+	return clone
+}
+
+// setRand 设置了Rand时为RandAware中的策略设置随机数来源
+func (config *Config) setRand() {
+	if config.Rand == nil {
+		return
+	}
+	for _, randAware := range config.RandAware {
+		randAware.SetRand(config.Rand)
+	}
+}
+
+// NewConfigChecked 创建配置并校验配置是否合法
+func NewConfigChecked(opts ...Option) (*Config, error) {
+	config := NewConfig(opts...)
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Validate 校验配置是否合法
+func (config *Config) Validate() error {
+	if config.RetryTimes < 0 && config.RetryTimes != Infinite {
+		return fmt.Errorf("retry: invalid retry times %d, must be non-negative or Infinite", config.RetryTimes)
+	}
+	if config.MinDelay < 0 {
+		return fmt.Errorf("retry: invalid min delay %v, must not be negative", config.MinDelay)
+	}
+	if config.MaxDelay < 0 {
+		return fmt.Errorf("retry: invalid max delay %v, must not be negative", config.MaxDelay)
+	}
+	if config.MaxElapsedTime < 0 {
+		return fmt.Errorf("retry: invalid max elapsed time %v, must not be negative", config.MaxElapsedTime)
+	}
+	if config.AttemptTimeout < 0 {
+		return fmt.Errorf("retry: invalid attempt timeout %v, must not be negative", config.AttemptTimeout)
+	}
+	return nil
+}
+
+// BreakError Break返回的错误, fn返回该错误(包括被fmt.Errorf等通过%w包装的情况)时立即中断重试
+type BreakError struct {
+	Err error
+}
+
+func (e BreakError) Error() string {
+	if e.Err == nil {
+		return "retry: break"
+	}
+	return e.Err.Error()
+}
+
+func (e BreakError) Unwrap() error {
+	return e.Err
 }
 
+// Break 包装err以立即中断重试. fn直接返回Break(err)时Do返回err, 执行一次OnFailed和OnGiveUp后返回;
+// err为nil时视为执行成功, 执行OnSuccess而不执行OnFailed.
+// Break(err)被包装后返回时同样中断重试, Do返回包装后的错误, 该错误被标记为已中断, 外层的Do不会因此再次中断重试;
+// 被包装的Break(nil)同样视为执行成功
 func Break(err error) error {
-	return breakError{err}
+	return BreakError{Err: err}
+}
+
+// wrappedBreakError 标记被包装的Break(err)已中断过一次重试, 错误信息和错误链与包装后的错误相同
+type wrappedBreakError struct {
+	err error
+}
+
+func (e *wrappedBreakError) Error() string {
+	return e.err.Error()
+}
+
+func (e *wrappedBreakError) Unwrap() error {
+	return e.err
+}
+
+// unwrapBreak 判断err是否为Break返回的错误或包装了该错误, 返回中断重试时应返回的错误
+func unwrapBreak(err error) (error, bool) {
+	if v, ok := err.(BreakError); ok {
+		return v.Err, true
+	}
+	// 只有包装了其他错误时才可能包含BreakError, 避免为未包装的错误调用errors.As产生内存分配
+	switch err.(type) {
+	case interface{ Unwrap() error }, interface{ Unwrap() []error }:
+		var wrapped *wrappedBreakError
+		if errors.As(err, &wrapped) {
+			return err, false
+		}
+		var v BreakError
+		if errors.As(err, &v) {
+			if v.Err == nil {
+				return nil, true
+			}
+			return &wrappedBreakError{err: err}, true
+		}
+	}
+	return err, false
+}
+
+// Infinite 重试次数设置为Infinite时无限重试, 仅在ctx结束、Break、RetryIf等情况下中断
+const Infinite = -1
+
+// Result 执行结果
+type Result struct {
+	// Attempts fn实际执行的次数
+	Attempts int
+	// TotalDelay 等待时间之和
+	TotalDelay time.Duration
+	// Err 最终返回的错误
+	Err error
+	// LastErr 最后一次执行fn返回的错误
+	LastErr error
+}
+
+// ErrNilFunc 执行的函数为nil时返回
+var ErrNilFunc = errors.New("retry: nil func")
+
+// ErrRetryResult 设置了WithRetryOnResult时, fn的结果需要重试时作为该次执行的错误传给OnFailed、RetryIf等
+var ErrRetryResult = errors.New("retry: result needs retry")
+
+// ErrStopped 设置了WithStopChannel时, 通道关闭后不再重试并返回
+var ErrStopped = errors.New("retry: stopped")
+
+// ErrNotEnoughSuccesses 设置了ConsecutiveSuccesses时, 重试次数用尽仍未达到连续成功次数时返回
+var ErrNotEnoughSuccesses = errors.New("retry: not enough consecutive successes")
+
+// ErrRetriesExhausted 设置了WithTypedErrors时, 重试次数用尽后返回的错误满足errors.Is(err, ErrRetriesExhausted)
+var ErrRetriesExhausted = errors.New("retry: retries exhausted")
+
+// RetriesExhaustedError 设置了WithTypedErrors时, 重试次数用尽后返回的错误, 包装最后一次执行返回的错误
+type RetriesExhaustedError struct {
+	Err      error
+	Attempts int
+}
+
+func (e *RetriesExhaustedError) Error() string {
+	return fmt.Sprintf("retry: retries exhausted after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *RetriesExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RetriesExhaustedError) Is(target error) bool {
+	return target == ErrRetriesExhausted
+}
+
+// ContextError 设置了WrapContextError时, 重试过程中ctx结束时返回的错误, 可通过errors.Is匹配ctx.Err()和最后一次执行返回的错误
+type ContextError struct {
+	// Err ctx.Err()
+	Err error
+	// LastErr 最后一次执行fn返回的错误, 首次执行前ctx已结束时为nil
+	LastErr  error
+	attempts int
+}
+
+func (e *ContextError) Error() string {
+	if e.LastErr == nil {
+		return fmt.Sprintf("retry: %v after %d attempts", e.Err, e.attempts)
+	}
+	return fmt.Sprintf("retry: %v after %d attempts, last error: %v", e.Err, e.attempts, e.LastErr)
+}
+
+func (e *ContextError) Unwrap() []error {
+	if e.LastErr == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Err, e.LastErr}
+}
+
+// Attempts 返回ctx结束前fn实际执行的次数
+func (e *ContextError) Attempts() int {
+	return e.attempts
+}
+
+// UnrecoverableError 不可恢复的错误, fn返回该错误时与Break一样立即中断重试, 但返回的错误保留该类型以便调用方判断
+type UnrecoverableError struct {
+	Err error
+}
+
+func (e *UnrecoverableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UnrecoverableError) Unwrap() error {
+	return e.Err
+}
+
+// Unrecoverable 将err标记为不可恢复的错误, err为nil时返回nil
+func Unrecoverable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &UnrecoverableError{Err: err}
+}
+
+// IsUnrecoverable 判断err是否为不可恢复的错误
+func IsUnrecoverable(err error) bool {
+	var unrecoverableErr *UnrecoverableError
+	return errors.As(err, &unrecoverableErr)
 }
 
 func (config *Config) Do(ctx context.Context, fn func() error) error {
+	_, err := config.DoN(ctx, fn)
+	return err
+}
+
+// DoN 同Do, 额外返回fn实际执行的次数
+func (config *Config) DoN(ctx context.Context, fn func() error) (int, error) {
+	result := config.DoResult(ctx, fn)
+	return result.Attempts, result.Err
+}
+
+// DoResult 同Do, 返回包含执行次数、等待时间和错误的执行结果
+func (config *Config) DoResult(ctx context.Context, fn func() error) Result {
+	if fn == nil {
+		return Result{Err: ErrNilFunc}
+	}
+	if config.once() {
+		return config.doOnce(ctx, fn)
+	}
+	return config.do(ctx, func(context.Context) error { return fn() })
+}
+
+// once 判断是否只需执行一次fn且不需要回调、日志、指标等额外处理, 此时可跳过重试的完整流程
+func (config *Config) once() bool {
+	return config.RetryTimes == 0 && config.Name == "" && config.ConsecutiveSuccesses <= 1 &&
+		config.OnFailed == nil && config.OnFailedErr == nil && config.OnSuccess == nil && config.OnGiveUp == nil &&
+		config.BeforeAttempt == nil && config.AfterAttempt == nil && config.OnAttemptDuration == nil && config.Recover == nil &&
+		config.AttemptTimeout == 0 && config.AttemptTimeoutFunc == nil && config.InitialDelay == 0 && config.StartupJitter == 0 &&
+		config.ErrorTransform == nil && len(config.SuccessOn) == 0 && !config.CombineErrors &&
+		config.ExhaustedError == nil && !config.TypedErrors && !config.TrailingDelay &&
+		config.CircuitBreaker == nil && config.RateLimiter == nil && config.Events == nil &&
+		config.Logger == nil && config.Metrics == nil && config.Tracer == nil && config.AttemptCounter == nil
+}
 
+// doOnce 只执行一次fn, 结果与do相同但不产生额外的内存分配
+func (config *Config) doOnce(ctx context.Context, fn func() error) Result {
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	if err := ctx.Err(); err != nil {
-		return err
+		return Result{Err: config.contextError(err, nil, 0)}
+	}
+	err, _ := unwrapBreak(fn())
+	return Result{Attempts: 1, Err: err, LastErr: err}
+}
+
+// DoCtx 同Do, fn接收每次执行使用的context, 设置了AttemptTimeout时为带超时的子context
+func (config *Config) DoCtx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return config.do(ctx, fn).Err
+}
+
+func (config *Config) do(ctx context.Context, fn func(ctx context.Context) error) Result {
+	if fn == nil {
+		return Result{Err: ErrNilFunc}
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return Result{Err: config.contextError(err, nil, 0)}
+	}
+
+	onRetry := config.OnRetryErr
+	if onRetry == nil {
+		onRetry = func(n int) error {
+			if config.OnRetry != nil {
+				config.OnRetry(n)
+			}
+			return nil
+		}
 	}
 
-	if config.OnRetry == nil {
-		config.OnRetry = func(n int) {}
+	onFailed := config.OnFailedErr
+	if onFailed == nil {
+		onFailed = func(n int, err error) error {
+			if config.OnFailed != nil {
+				config.OnFailed(n, err)
+			}
+			return nil
+		}
+	}
+
+	onSuccess := config.OnSuccess
+	if onSuccess == nil {
+		onSuccess = func(n int) {}
+	}
+
+	onGiveUp := config.OnGiveUp
+	if onGiveUp == nil {
+		onGiveUp = func(attempts int, err error) {}
+	}
+
+	delayStrategy := config.DelayStrategyV2
+	if config.ContextDelayStrategy != nil {
+		strategy := config.ContextDelayStrategy
+		delayStrategy = func(n int, elapsed time.Duration, err error) time.Duration {
+			return strategy(ctx, n, err)
+		}
+	}
+	if delayStrategy == nil {
+		if config.DelayStrategy != nil {
+			delayStrategy = adaptDelayStrategy(config.DelayStrategy)
+		} else {
+			delayStrategy = adaptDelayStrategy(FixedDelay(0))
+		}
+	}
+	if len(config.DelayOverrides) > 0 {
+		delayStrategy = overrideDelayStrategy(delayStrategy, config.DelayOverrides)
+	}
+	if config.FirstRetryDelay != nil {
+		strategy, firstRetryDelay := delayStrategy, *config.FirstRetryDelay
+		delayStrategy = func(n int, elapsed time.Duration, err error) time.Duration {
+			if n == 0 && err != nil {
+				return firstRetryDelay
+			}
+			return strategy(n, elapsed, err)
+		}
+	}
+
+	retryIf := config.RetryIf
+	if retryIf == nil {
+		retryIf = func(err error) bool { return true }
+	}
+
+	tracer := config.Tracer
+	if tracer == nil {
+		tracer = NopTracer{}
+	}
+
+	var result Result
+	ctx, endSpan := tracer.StartSpan(ctx, "retry.Do")
+	defer func() { endSpan(result.Err) }()
+	finish := func(attempts int, err error) Result {
+		result.Attempts, result.Err = attempts, err
+		return result
 	}
 
-	if config.OnFailed == nil {
-		config.OnFailed = func(n int, err error) {}
+	clock := config.clock()
+
+	sleeper := &sleeper{clock: config.Clock, stopCh: config.Stop, onInterrupted: config.OnDelayInterrupted, spinThreshold: config.SpinThreshold, pauseCh: config.Pause}
+	defer sleeper.stop()
+	defer sleeper.unwatchPause()
+
+	start := clock.Now()
+	if config.InitialDelay > 0 {
+		if err := sleeper.sleep(ctx, config.InitialDelay); err != nil {
+			return finish(0, config.sleepError(err, nil, 0))
+		}
+		result.TotalDelay += config.InitialDelay
+	}
+	if config.StartupJitter > 0 {
+		int63n := rand.Int63n
+		if config.Rand != nil {
+			int63n = config.Rand.Int63n
+		}
+		jitter := time.Duration(int63n(int64(config.StartupJitter)))
+		if err := sleeper.sleep(ctx, jitter); err != nil {
+			return finish(0, config.sleepError(err, nil, 0))
+		}
+		result.TotalDelay += jitter
 	}
 
-	if config.DelayStrategy == nil {
-		config.DelayStrategy = FixedDelay(0)
+	metrics := config.Metrics
+	if metrics == nil {
+		metrics = NopMetrics{}
+	}
+
+	giveUp := func(attempts int, err error) Result {
+		metrics.IncGiveUp()
+		config.logf("giving up after %d attempts: %v", attempts, err)
+		if config.Name != "" {
+			err = fmt.Errorf("retry %q gave up after %d attempts: %w", config.Name, attempts, err)
+		}
+		onGiveUp(attempts, err)
+		return finish(attempts, err)
 	}
 
 	var n int
+	var successes int
+	var repeated int
+	var failedProbes int
+	var errs []error
+	perErrorCounts := make([]int, len(config.PerErrorLimits))
 	for {
+		if err := ctx.Err(); err != nil {
+			return finish(n, config.contextError(err, result.LastErr, n))
+		}
+
+		if config.RateLimiter != nil {
+			if err := config.RateLimiter.Wait(ctx); err != nil {
+				if ctx.Err() != nil {
+					err = config.contextError(err, result.LastErr, n)
+				}
+				return finish(n, err)
+			}
+		}
+
+		if config.CircuitBreaker != nil && !config.CircuitBreaker.Allow() {
+			return giveUp(n, ErrCircuitOpen)
+		}
+
+		if n == 0 && config.AttemptCounter != nil && !config.AttemptCounter.Acquire() {
+			return giveUp(0, ErrAttemptLimit)
+		}
+
 		if n > 0 {
-			config.OnRetry(n)
+			metrics.IncRetry()
+			if err := onRetry(n); err != nil {
+				return giveUp(n, err)
+			}
 		}
 
-		err := fn()
+		metrics.IncAttempt()
+		sleeper.watchPause()
+		err := config.attempt(ctx, tracer, n, fn)
+		sleeper.unwatchPause()
 
-		v, breakRetry := err.(breakError)
-		if breakRetry {
-			err = v.error
+		if err != nil && config.ErrorTransform != nil {
+			err = config.ErrorTransform(err)
+		}
+		if err != nil && config.successOn(err) {
+			err = nil
+		}
+
+		err, breakRetry := unwrapBreak(err)
+
+		if config.CircuitBreaker != nil {
+			config.CircuitBreaker.Report(err == nil)
+		}
+
+		if err != nil {
+			result.LastErr = err
 		}
 
 		if err == nil {
-			return nil
+			successes++
+			repeated = 0
+			if breakRetry || successes >= config.ConsecutiveSuccesses {
+				onSuccess(n)
+				return finish(n+1, nil)
+			}
+			if config.exhausted(n) || config.ContinueIf != nil && !config.ContinueIf() {
+				return giveUp(n+1, ErrNotEnoughSuccesses)
+			}
+			delay := delayStrategy(n, clock.Now().Sub(start), nil)
+			if delay == StopDelay {
+				return giveUp(n+1, ErrNotEnoughSuccesses)
+			}
+			delay = config.clampDelay(delay)
+			if config.MaxElapsedTime > 0 && clock.Now().Sub(start)+delay > config.MaxElapsedTime ||
+				config.Budget != nil && !config.Budget.Allow() ||
+				config.AttemptCounter != nil && !config.AttemptCounter.Acquire() {
+				return giveUp(n+1, ErrNotEnoughSuccesses)
+			}
+			if err := sleeper.sleep(ctx, delay); err != nil {
+				return finish(n+1, config.sleepError(err, result.LastErr, n+1))
+			}
+			result.TotalDelay += delay
+			n = next(n)
+			continue
 		}
+		successes = 0
 
-		config.OnFailed(n, err)
+		if config.CombineErrors || config.AbortIf != nil {
+			errs = append(errs, err)
+		}
+
+		if cbErr := onFailed(n, err); cbErr != nil {
+			return giveUp(n+1, cbErr)
+		}
 
-		if n >= config.RetryTimes {
+		exhausted := !breakRetry && config.exhausted(n) && !IsUnrecoverable(err)
+		if config.RepeatedError != nil {
+			if errors.Is(err, config.RepeatedError) {
+				repeated++
+			} else {
+				repeated = 0
+			}
+		}
+		var perErrorExceeded bool
+		for i, limit := range config.PerErrorLimits {
+			if limit.Match(err) {
+				perErrorCounts[i]++
+				perErrorExceeded = perErrorCounts[i] > limit.Limit
+				break
+			}
+		}
+		var classifiedDelay time.Duration
+		var useClassifiedDelay bool
+		if config.exhausted(n) || IsUnrecoverable(err) || config.RepeatedError != nil && repeated >= config.RepeatedErrorThreshold || perErrorExceeded ||
+			config.AbortIf != nil && config.AbortIf(errs) {
 			breakRetry = true
+		} else if config.Classifier != nil {
+			var retry bool
+			retry, classifiedDelay, useClassifiedDelay = config.Classifier(err)
+			breakRetry = !retry
+		} else if !retryIf(err) {
+			breakRetry = true
+		}
+		if !breakRetry && config.ContinueIf != nil && !config.ContinueIf() {
+			breakRetry = true
+		}
+
+		var delay time.Duration
+		if !breakRetry {
+			if useClassifiedDelay {
+				delay = classifiedDelay
+			} else {
+				delay = delayStrategy(n, clock.Now().Sub(start), err)
+			}
+			if delay == StopDelay {
+				breakRetry = true
+			} else {
+				delay = config.clampDelay(delay)
+				breakRetry = config.MaxElapsedTime > 0 && clock.Now().Sub(start)+delay > config.MaxElapsedTime ||
+					config.Budget != nil && !config.Budget.Allow() ||
+					config.AttemptCounter != nil && !config.AttemptCounter.Acquire()
+			}
 		}
 
 		if breakRetry {
-			return err
+			delay = 0
+		}
+		config.emit(RetryEvent{Type: EventFailed, Name: config.Name, Attempt: n, Err: err, Delay: delay, Time: clock.Now()})
+
+		if breakRetry {
+			if exhausted && config.TrailingDelay {
+				if delay := delayStrategy(n, clock.Now().Sub(start), err); delay != StopDelay {
+					delay = config.clampDelay(delay)
+					if ctxErr := sleeper.sleep(ctx, delay); ctxErr != nil {
+						return finish(n+1, config.sleepError(ctxErr, err, n+1))
+					}
+					result.TotalDelay += delay
+				}
+			}
+			if config.CombineErrors {
+				err = errors.Join(errs...)
+			}
+			if exhausted && config.ExhaustedError != nil {
+				err = config.ExhaustedError(err, n+1)
+			}
+			if exhausted && config.TypedErrors {
+				err = &RetriesExhaustedError{Err: err, Attempts: n + 1}
+			}
+			return giveUp(n+1, err)
+		}
+
+		config.logf("attempt %d failed: %v, retrying in %v", n, err, delay)
+		metrics.ObserveDelay(delay)
+
+		if ctxErr := sleeper.sleep(ctx, delay); ctxErr != nil {
+			return finish(n+1, config.sleepError(ctxErr, err, n+1))
+		}
+		result.TotalDelay += delay
+
+		if config.HealthCheck != nil {
+			probeDelay := max(delay, healthCheckMinDelay)
+			for {
+				probeErr := config.HealthCheck(ctx)
+				if probeErr == nil {
+					break
+				}
+				failedProbes++
+				if config.RetryTimes != Infinite && failedProbes > config.RetryTimes ||
+					config.MaxElapsedTime > 0 && clock.Now().Sub(start)+probeDelay > config.MaxElapsedTime {
+					config.logf("health check failed: %v", probeErr)
+					return giveUp(n+1, err)
+				}
+				config.logf("health check failed: %v, probing again in %v", probeErr, probeDelay)
+				if ctxErr := sleeper.sleep(ctx, probeDelay); ctxErr != nil {
+					return finish(n+1, config.sleepError(ctxErr, err, n+1))
+				}
+				result.TotalDelay += probeDelay
+				probeDelay = config.nextProbeDelay(probeDelay)
+			}
+		}
+		n = next(n)
+		config.emit(RetryEvent{Type: EventRetry, Name: config.Name, Attempt: n, Err: err, Delay: delay, Time: clock.Now()})
+	}
+}
+
+// successOn 判断err是否满足SuccessOn中的任一条件
+func (config *Config) successOn(err error) bool {
+	for _, match := range config.SuccessOn {
+		if match(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// exhausted 判断第n次执行后重试次数是否已用尽
+func (config *Config) exhausted(n int) bool {
+	return config.RetryTimes != Infinite && n >= config.RetryTimes
+}
+
+// next 返回下次执行的次数, 无限重试时不超过math.MaxInt-1以避免溢出
+func next(n int) int {
+	if n < math.MaxInt-1 {
+		n++
+	}
+	return n
+}
+
+// sleeper 复用同一个定时器等待, 避免每次等待都创建新的定时器, 设置了clock时使用clock等待
+type sleeper struct {
+	clock         Clock
+	timer         *time.Timer
+	stopCh        <-chan struct{}
+	onInterrupted OnDelayInterruptedFunc
+	// spinThreshold 小于该值的等待使用自旋实现, 仅在未设置clock时生效
+	spinThreshold time.Duration
+	pauseCh       <-chan bool
+	paused        bool
+	// pauseStop 非nil时watchPause启动的goroutine正在接收pauseCh, 关闭后该goroutine通过pauseState返回暂停状态
+	pauseStop  chan struct{}
+	pauseState chan pauseSignal
+}
+
+// pauseSignal 从pauseCh接收的暂停信号, ok为false表示pauseCh已关闭
+type pauseSignal struct {
+	paused, ok bool
+}
+
+// sleep 等待delay, 等待前或等待期间ctx结束时返回ctx.Err(), stopCh关闭时返回ErrStopped, 等待期间被中断时调用onInterrupted.
+// 等待期间接收pauseCh的暂停信号, 等待结束时处于暂停状态则继续等待恢复
+func (s *sleeper) sleep(ctx context.Context, delay time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case <-s.stopCh:
+		return ErrStopped
+	default:
+	}
+	if delay <= 0 {
+		return s.resume(ctx)
+	}
+	if s.clock == nil && delay < s.spinThreshold {
+		return s.spin(ctx, delay)
+	}
+	var c <-chan time.Time
+	var start time.Time
+	if s.clock != nil {
+		start = s.clock.Now()
+		c = s.clock.After(delay)
+	} else {
+		if s.timer == nil {
+			s.timer = time.NewTimer(delay)
+		} else {
+			s.timer.Reset(delay)
+		}
+		start = time.Now()
+		c = s.timer.C
+	}
+	for {
+		select {
+		case <-c:
+			return s.resume(ctx)
+		case <-ctx.Done():
+			s.interrupted(delay, start)
+			return ctx.Err()
+		case <-s.stopCh:
+			s.interrupted(delay, start)
+			return ErrStopped
+		case paused, ok := <-s.pauseCh:
+			s.setPaused(paused, ok)
+		}
+	}
+}
+
+// spin 以自旋的方式等待delay, 每次循环检查ctx和stopCh并调用runtime.Gosched让出CPU
+func (s *sleeper) spin(ctx context.Context, delay time.Duration) error {
+	start := time.Now()
+	for time.Since(start) < delay {
+		select {
+		case <-ctx.Done():
+			s.interrupted(delay, start)
+			return ctx.Err()
+		case <-s.stopCh:
+			s.interrupted(delay, start)
+			return ErrStopped
+		case paused, ok := <-s.pauseCh:
+			s.setPaused(paused, ok)
+		default:
 		}
+		runtime.Gosched()
+	}
+	return s.resume(ctx)
+}
 
+// resume 处于暂停状态时等待恢复, 等待期间ctx结束时返回ctx.Err(), stopCh关闭时返回ErrStopped
+func (s *sleeper) resume(ctx context.Context) error {
+	for {
+		select {
+		case paused, ok := <-s.pauseCh:
+			s.setPaused(paused, ok)
+			continue
+		default:
+		}
+		if !s.paused {
+			return nil
+		}
 		select {
-		case <-time.After(config.DelayStrategy(n, err)):
-			n++
+		case paused, ok := <-s.pauseCh:
+			s.setPaused(paused, ok)
 		case <-ctx.Done():
 			return ctx.Err()
+		case <-s.stopCh:
+			return ErrStopped
+		}
+	}
+}
+
+// watchPause 执行fn期间在goroutine中接收pauseCh的信号, 使发送方不会因等待sleep而阻塞, 由unwatchPause停止
+func (s *sleeper) watchPause() {
+	if s.pauseCh == nil {
+		return
+	}
+	s.pauseStop = make(chan struct{})
+	s.pauseState = make(chan pauseSignal, 1)
+	go func(pauseCh <-chan bool, stop <-chan struct{}, state chan<- pauseSignal, paused bool) {
+		for {
+			select {
+			case p, ok := <-pauseCh:
+				if !ok {
+					state <- pauseSignal{ok: false}
+					return
+				}
+				paused = p
+			case <-stop:
+				state <- pauseSignal{paused: paused, ok: true}
+				return
+			}
+		}
+	}(s.pauseCh, s.pauseStop, s.pauseState, s.paused)
+}
+
+// unwatchPause 停止watchPause启动的goroutine并取回其接收到的暂停状态
+func (s *sleeper) unwatchPause() {
+	if s.pauseStop == nil {
+		return
+	}
+	close(s.pauseStop)
+	signal := <-s.pauseState
+	s.pauseStop, s.pauseState = nil, nil
+	s.setPaused(signal.paused, signal.ok)
+}
+
+// setPaused 根据pauseCh接收的信号更新暂停状态, pauseCh关闭时视为恢复且不再接收
+func (s *sleeper) setPaused(paused, ok bool) {
+	if !ok {
+		s.pauseCh = nil
+		paused = false
+	}
+	s.paused = paused
+}
+
+// interrupted 停止定时器并调用onInterrupted
+func (s *sleeper) interrupted(delay time.Duration, start time.Time) {
+	s.stop()
+	if s.onInterrupted != nil {
+		remaining := delay - s.since(start)
+		if remaining < 0 {
+			remaining = 0
+		}
+		s.onInterrupted(remaining)
+	}
+}
+
+func (s *sleeper) since(t time.Time) time.Duration {
+	if s.clock != nil {
+		return s.clock.Now().Sub(t)
+	}
+	return time.Since(t)
+}
+
+// stop 停止定时器并清空未读取的信号, 以便再次Reset
+func (s *sleeper) stop() {
+	if s.timer != nil && !s.timer.Stop() {
+		select {
+		case <-s.timer.C:
+		default:
+		}
+	}
+}
+
+// logf 设置了Logger时输出日志, 设置了Name时日志中包含Name
+func (config *Config) logf(format string, args ...any) {
+	if config.Logger == nil {
+		return
+	}
+	prefix := "retry: "
+	if config.Name != "" {
+		prefix = fmt.Sprintf("retry %q: ", config.Name)
+	}
+	config.Logger.Printf(prefix+format, args...)
+}
+
+// sleepError 返回等待被中断时的错误, 停止信号关闭时返回ErrStopped, 设置了PreserveLastError时同时包装最后一次执行返回的错误,
+// ctx结束时同contextError
+func (config *Config) sleepError(err, lastErr error, attempts int) error {
+	if err != ErrStopped {
+		return config.contextError(err, lastErr, attempts)
+	}
+	if config.PreserveLastError && lastErr != nil {
+		return fmt.Errorf("%w: %w", err, lastErr)
+	}
+	return err
+}
+
+// contextError 返回执行attempts次后ctx结束时的错误, 设置了WrapContextError时返回*ContextError,
+// 设置了PreserveLastError时同时包装最后一次执行返回的错误
+func (config *Config) contextError(ctxErr, lastErr error, attempts int) error {
+	if config.OnContextCancel != nil {
+		config.OnContextCancel(attempts, lastErr)
+	}
+	if config.WrapContextError {
+		return &ContextError{Err: ctxErr, LastErr: lastErr, attempts: attempts}
+	}
+	if config.PreserveLastError && lastErr != nil {
+		return fmt.Errorf("%w: %w", ctxErr, lastErr)
+	}
+	return ctxErr
+}
+
+// AdaptContextDelayStrategy 将DelayStrategy转换为ContextDelayStrategy, 转换后的策略忽略ctx, 便于在ContextDelayStrategy中组合已有的策略
+func AdaptContextDelayStrategy(strategy DelayStrategy) ContextDelayStrategy {
+	return func(ctx context.Context, n int, err error) time.Duration {
+		return strategy(n, err)
+	}
+}
+
+// adaptDelayStrategy 将DelayStrategy转换为DelayStrategyV2
+func adaptDelayStrategy(strategy DelayStrategy) DelayStrategyV2 {
+	return func(n int, elapsed time.Duration, err error) time.Duration {
+		return strategy(n, err)
+	}
+}
+
+// overrideDelayStrategy 执行失败时使用第一个匹配错误的DelayOverride计算时间间隔, 均不匹配时使用strategy
+func overrideDelayStrategy(strategy DelayStrategyV2, overrides []DelayOverride) DelayStrategyV2 {
+	return func(n int, elapsed time.Duration, err error) time.Duration {
+		if err != nil {
+			for _, override := range overrides {
+				if override.Match(err) {
+					return override.Strategy(n, err)
+				}
+			}
+		}
+		return strategy(n, elapsed, err)
+	}
+}
+
+// clampDelay 按MinDelay和MaxDelay限制时间间隔, 先应用下限再应用上限, 溢出为负数时取上限
+func (config *Config) clampDelay(delay time.Duration) time.Duration {
+	if delay < 0 && config.MaxDelay > 0 {
+		return config.MaxDelay
+	}
+	if delay < config.MinDelay {
+		delay = config.MinDelay
+	}
+	if config.MaxDelay > 0 && delay > config.MaxDelay {
+		delay = config.MaxDelay
+	}
+	return delay
+}
+
+const (
+	// healthCheckMinDelay 健康检查探测失败后的最短等待时间, 避免重试间隔为0时连续探测
+	healthCheckMinDelay = 10 * time.Millisecond
+	// healthCheckMaxDelay 未设置MaxDelay时健康检查探测失败后的最长等待时间
+	healthCheckMaxDelay = time.Minute
+)
+
+// nextProbeDelay 返回健康检查下次探测失败后的等待时间, 每次翻倍, 不超过MaxDelay(未设置时为healthCheckMaxDelay)
+func (config *Config) nextProbeDelay(delay time.Duration) time.Duration {
+	maxDelay := healthCheckMaxDelay
+	if config.MaxDelay > 0 {
+		maxDelay = config.MaxDelay
+	}
+	return max(min(delay*2, maxDelay), delay)
+}
+
+// clock 返回设置的时钟, 未设置时为真实时间
+func (config *Config) clock() Clock {
+	if config.Clock == nil {
+		return realClock{}
+	}
+	return config.Clock
+}
+
+type attemptKey struct{}
+
+// AttemptFromContext 获取fn接收的ctx中当前的执行次数(0表示首次调用), ctx中不存在时返回0
+func AttemptFromContext(ctx context.Context) int {
+	n, _ := ctx.Value(attemptKey{}).(int)
+	return n
+}
+
+// attempt 第n次执行fn并创建对应的span, 前后分别调用BeforeAttempt和AfterAttempt, 设置了AttemptTimeout或AttemptTimeoutFunc时使用带超时的子context,
+// 设置了Recover时将fn的panic转换为错误, ShouldRetryOnPanic返回false时转换后的错误标记为不可重试
+func (config *Config) attempt(ctx context.Context, tracer Tracer, n int, fn func(ctx context.Context) error) (err error) {
+	ctx, endSpan := tracer.StartSpan(ctx, "retry.attempt")
+	defer func() { endSpan(err) }()
+	ctx = context.WithValue(ctx, attemptKey{}, n)
+	timeout := config.AttemptTimeout
+	if config.AttemptTimeoutFunc != nil {
+		timeout = config.AttemptTimeoutFunc(n)
+	}
+	if config.EvenlyBudgetedAttempts && config.RetryTimes != Infinite {
+		if deadline, ok := ctx.Deadline(); ok {
+			even := time.Until(deadline) / time.Duration(max(config.RetryTimes-n+1, 1))
+			if even > 0 && (timeout <= 0 || even < timeout) {
+				timeout = even
+			}
+		}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if config.BeforeAttempt != nil {
+		if err := config.BeforeAttempt(n); err != nil {
+			return err
 		}
 	}
+	if config.AfterAttempt != nil {
+		defer func() { config.AfterAttempt(n, err) }()
+	}
+	if config.OnAttemptDuration != nil {
+		clock := config.clock()
+		start := clock.Now()
+		defer func() { config.OnAttemptDuration(n, clock.Now().Sub(start), err) }()
+	}
+	if config.Recover != nil {
+		defer func() {
+			if r := recover(); r != nil {
+				if p, ok := r.(loopBodyPanic); ok {
+					panic(p)
+				}
+				err = config.Recover(r)
+				if config.ShouldRetryOnPanic != nil && !config.ShouldRetryOnPanic(r) {
+					err = Unrecoverable(err)
+				}
+			}
+		}()
+	}
+	return fn(ctx)
 }
 
 func Do(ctx context.Context, fn func() error, opts ...Option) error {
 	return NewConfig(opts...).Do(ctx, fn)
 }
+
+// DoN 同Do, 额外返回fn实际执行的次数, 首次执行成功时为1, 全部失败时为RetryTimes+1
+func DoN(ctx context.Context, fn func() error, opts ...Option) (int, error) {
+	return NewConfig(opts...).DoN(ctx, fn)
+}
+
+// DoResult 同Do, 返回包含执行次数、等待时间和错误的执行结果, 其中Err与Do的返回值相同
+func DoResult(ctx context.Context, fn func() error, opts ...Option) Result {
+	return NewConfig(opts...).DoResult(ctx, fn)
+}
+
+// DoCtx 同Do, fn接收ctx以便在执行过程中感知取消, 设置了AttemptTimeout时为带超时的子context
+func DoCtx(ctx context.Context, fn func(ctx context.Context) error, opts ...Option) error {
+	return NewConfig(opts...).DoCtx(ctx, fn)
+}
+
+// DoFunc 同Do, fn通过返回值retry决定是否重试: retry为false时直接返回err(err为nil时视为成功),
+// retry为true且err不为nil时按配置重试, err为nil时视为成功
+func DoFunc(ctx context.Context, fn func() (retry bool, err error), opts ...Option) error {
+	if fn == nil {
+		return ErrNilFunc
+	}
+	return Do(ctx, func() error {
+		retry, err := fn()
+		if !retry && err != nil {
+			return Break(err)
+		}
+		return err
+	}, opts...)
+}
+
+// DoWithData 执行带返回值的函数fn并在失败时重试, 成功时返回fn的结果, 失败时返回零值和最后一次执行返回的错误,
+// 使用Break中断时返回该次执行的结果和错误. 设置了WithRetryOnResult时, 重试次数用尽仍需重试的结果与nil错误一起返回
+func DoWithData[T any](ctx context.Context, fn func() (T, error), opts ...Option) (T, error) {
+	data, _, err := DoWithDataN(ctx, fn, opts...)
+	return data, err
+}
+
+// DoWithDataN 同DoWithData, 额外返回fn实际执行的次数, 与DoN相同
+func DoWithDataN[T any](ctx context.Context, fn func() (T, error), opts ...Option) (T, int, error) {
+	var data, zero T
+	if fn == nil {
+		return zero, 0, ErrNilFunc
+	}
+	config := NewConfig(opts...)
+	retryOnResult, _ := config.RetryOnResult.(func(T) bool)
+	var breakRetry, retryResult bool
+	attempts, err := config.DoN(ctx, func() error {
+		var err error
+		data, err = fn()
+		_, breakRetry = unwrapBreak(err)
+		// 只有最后一次执行因结果重试时才返回结果, 合并的错误中来自之前执行的ErrRetryResult不影响返回值
+		retryResult = err == nil && retryOnResult != nil && retryOnResult(data)
+		if retryResult {
+			return ErrRetryResult
+		}
+		return err
+	})
+	if retryResult && errors.Is(err, ErrRetryResult) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		return data, attempts, nil
+	}
+	if err != nil && !breakRetry {
+		return zero, attempts, err
+	}
+	return data, attempts, err
+}
@@ -1,11 +1,20 @@
 package retry
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/panyc0217/retry/retrytest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -276,6 +285,18 @@ func TestRandomDelay(t *testing.T) {
 		assert.Greater(t, duration, 2*delay-50*time.Millisecond)
 		assert.Less(t, duration, 2*delay+50*time.Millisecond)
 	})
+	t.Run("random delay with source", func(t *testing.T) {
+		minDelay := 100 * time.Millisecond
+		maxDelay := 200 * time.Millisecond
+		a := RandomDelayWithSource(minDelay, maxDelay, rand.New(rand.NewSource(1)))
+		b := RandomDelayWithSource(minDelay, maxDelay, rand.New(rand.NewSource(1)))
+		for n := 0; n < 10; n++ {
+			delay := a(n, testErr)
+			assert.Equal(t, delay, b(n, testErr))
+			assert.GreaterOrEqual(t, delay, minDelay)
+			assert.LessOrEqual(t, delay, maxDelay)
+		}
+	})
 }
 
 func TestBreak(t *testing.T) {
@@ -301,4 +322,4092 @@ func TestBreak(t *testing.T) {
 		assert.Equal(t, 1, exec)
 	})
 
+	t.Run("wrapped break", func(t *testing.T) {
+		exec := 0
+		err := Do(context.Background(), func() error {
+			exec++
+			return fmt.Errorf("query: %w", Break(testErr))
+		}, WithTimes(10))
+		assert.EqualError(t, err, "query: test")
+		assert.ErrorIs(t, err, testErr)
+		assert.Equal(t, 1, exec)
+	})
+
+	t.Run("callbacks", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			err   error
+			calls []string
+		}{
+			{name: "break nil", err: Break(nil), calls: []string{"success"}},
+			{name: "break error", err: Break(testErr), calls: []string{"failed", "give up"}},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				var calls []string
+				Do(context.Background(), func() error { return test.err },
+					WithTimes(3),
+					WithOnRetryFunc(func(n int) { calls = append(calls, "retry") }),
+					WithOnFailedFunc(func(n int, err error) {
+						assert.Equal(t, testErr, err)
+						calls = append(calls, "failed")
+					}),
+					WithOnSuccessFunc(func(n int) { calls = append(calls, "success") }),
+					WithOnGiveUpFunc(func(attempts int, err error) { calls = append(calls, "give up") }),
+				)
+				assert.Equal(t, test.calls, calls)
+			})
+		}
+	})
+
+	t.Run("wrapped break does not stop outer do", func(t *testing.T) {
+		outer := 0
+		err := Do(context.Background(), func() error {
+			outer++
+			return Do(context.Background(), func() error {
+				return fmt.Errorf("inner: %w", Break(testErr))
+			}, WithTimes(5))
+		}, WithTimes(5))
+		assert.EqualError(t, err, "inner: test")
+		assert.ErrorIs(t, err, testErr)
+		assert.Equal(t, 6, outer)
+	})
+
+	t.Run("custom wrapper kept", func(t *testing.T) {
+		exec := 0
+		err := Do(context.Background(), func() error {
+			exec++
+			return &breakWrapper{err: Break(testErr)}
+		}, WithTimes(5))
+		var wrapper *breakWrapper
+		assert.ErrorAs(t, err, &wrapper)
+		assert.ErrorIs(t, err, testErr)
+		assert.EqualError(t, err, "wrapper: test")
+		assert.Equal(t, 1, exec)
+	})
+
+	t.Run("joined sibling kept", func(t *testing.T) {
+		exec := 0
+		err := Do(context.Background(), func() error {
+			exec++
+			return errors.Join(Break(testErr), io.EOF)
+		}, WithTimes(5))
+		assert.ErrorIs(t, err, testErr)
+		assert.ErrorIs(t, err, io.EOF)
+		assert.Equal(t, 1, exec)
+	})
+
+	t.Run("wrapped break with nil", func(t *testing.T) {
+		exec := 0
+		var failed bool
+		err := Do(context.Background(), func() error {
+			exec++
+			return fmt.Errorf("w: %w", Break(nil))
+		}, WithTimes(10), WithOnFailedFunc(func(n int, err error) { failed = true }))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, exec)
+		assert.False(t, failed)
+	})
+
+	t.Run("joined break", func(t *testing.T) {
+		exec := 0
+		err := Do(context.Background(), func() error {
+			exec++
+			return errors.Join(io.EOF, Break(testErr))
+		}, WithTimes(10))
+		assert.ErrorIs(t, err, testErr)
+		assert.Equal(t, 1, exec)
+	})
+}
+
+type breakWrapper struct {
+	err error
+}
+
+func (e *breakWrapper) Error() string { return "wrapper: " + e.err.Error() }
+func (e *breakWrapper) Unwrap() error { return e.err }
+
+func TestDoWithData(t *testing.T) {
+	t.Run("success after retry", func(t *testing.T) {
+		exec := 0
+		data, err := DoWithData(context.Background(), func() (int, error) {
+			exec++
+			if exec >= 3 {
+				return exec, nil
+			}
+			return exec, testErr
+		}, WithTimes(5))
+		assert.Nil(t, err)
+		assert.Equal(t, 3, data)
+		assert.Equal(t, 3, exec)
+	})
+
+	t.Run("all failed", func(t *testing.T) {
+		exec := 0
+		data, err := DoWithData(context.Background(), func() (string, error) {
+			exec++
+			return "partial", testErr
+		}, WithTimes(2))
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, "", data)
+		assert.Equal(t, 3, exec)
+	})
+
+	t.Run("break with error", func(t *testing.T) {
+		exec := 0
+		data, err := DoWithData(context.Background(), func() (int, error) {
+			exec++
+			return 42, Break(testErr)
+		}, WithTimes(10))
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 42, data)
+		assert.Equal(t, 1, exec)
+	})
+}
+
+func TestRetryOnResult(t *testing.T) {
+	notReady := func(status string) bool { return status == "pending" }
+
+	t.Run("retry until ready", func(t *testing.T) {
+		statuses := []string{"pending", "pending", "done"}
+		var failed []error
+		exec := 0
+		data, err := DoWithData(context.Background(), func() (string, error) {
+			exec++
+			return statuses[exec-1], nil
+		},
+			WithTimes(5),
+			WithRetryOnResult(notReady),
+			WithOnFailedFunc(func(n int, err error) { failed = append(failed, err) }),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, "done", data)
+		assert.Equal(t, 3, exec)
+		assert.Equal(t, []error{ErrRetryResult, ErrRetryResult}, failed)
+	})
+
+	t.Run("last value on exhaustion", func(t *testing.T) {
+		exec := 0
+		data, err := DoWithData(context.Background(), func() (string, error) {
+			exec++
+			return "pending", nil
+		}, WithTimes(2), WithRetryOnResult(notReady), WithName("poll"))
+		assert.Nil(t, err)
+		assert.Equal(t, "pending", data)
+		assert.Equal(t, 3, exec)
+	})
+
+	t.Run("error still retried", func(t *testing.T) {
+		data, err := DoWithData(context.Background(), func() (string, error) {
+			return "", testErr
+		}, WithTimes(2), WithRetryOnResult(notReady))
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, "", data)
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		data, err := DoWithData(ctx, func() (string, error) {
+			cancel()
+			return "pending", nil
+		}, WithTimes(2), WithRetryOnResult(notReady), WithPreserveLastError())
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, "", data)
+	})
+
+	t.Run("type mismatch", func(t *testing.T) {
+		exec := 0
+		data, err := DoWithData(context.Background(), func() (int, error) {
+			exec++
+			return 1, nil
+		}, WithTimes(2), WithRetryOnResult(notReady))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, data)
+		assert.Equal(t, 1, exec)
+	})
+}
+
+func TestAbortOnRepeatedError(t *testing.T) {
+	permissionErr := errors.New("permission denied")
+	otherErr := errors.New("other")
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		err      error
+	}{
+		{name: "consecutive", errs: []error{permissionErr, permissionErr, permissionErr}, attempts: 3, err: permissionErr},
+		{name: "wrapped", errs: []error{permissionErr, fmt.Errorf("open: %w", permissionErr), permissionErr}, attempts: 3, err: permissionErr},
+		{name: "reset by other errors", errs: []error{permissionErr, permissionErr, otherErr, permissionErr, permissionErr, otherErr, permissionErr, permissionErr, permissionErr}, attempts: 9, err: permissionErr},
+		{name: "reset by success", errs: []error{permissionErr, permissionErr, nil, permissionErr, permissionErr, permissionErr}, attempts: 6, err: permissionErr},
+		{name: "not reached", errs: []error{permissionErr, otherErr, permissionErr, otherErr, permissionErr, otherErr, permissionErr, otherErr, permissionErr, otherErr, permissionErr}, attempts: 11, err: permissionErr},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exec := 0
+			attempts, err := DoN(context.Background(), func() error {
+				exec++
+				return test.errs[exec-1]
+			},
+				WithTimes(10),
+				WithAbortOnRepeatedError(permissionErr, 3),
+				WithUntilConsecutiveSuccess(2),
+			)
+			assert.Equal(t, test.attempts, attempts)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestDoFunc(t *testing.T) {
+	tests := []struct {
+		name     string
+		retry    bool
+		err      error
+		attempts int
+	}{
+		{name: "no retry with error", retry: false, err: testErr, attempts: 1},
+		{name: "no retry without error", retry: false, err: nil, attempts: 1},
+		{name: "retry with error", retry: true, err: testErr, attempts: 3},
+		{name: "retry without error", retry: true, err: nil, attempts: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			err := DoFunc(context.Background(), func() (bool, error) {
+				attempts++
+				return test.retry, test.err
+			}, WithTimes(2))
+			assert.Equal(t, test.err, err)
+			assert.Equal(t, test.attempts, attempts)
+		})
+	}
+
+	t.Run("nil func", func(t *testing.T) {
+		assert.Equal(t, ErrNilFunc, DoFunc(context.Background(), nil))
+	})
+}
+
+func TestRetryIf(t *testing.T) {
+	permanentErr := errors.New("permanent")
+	t.Run("stop on non-retryable error", func(t *testing.T) {
+		exec := 0
+		onFailedCount := 0
+		s := time.Now()
+		err := Do(context.Background(), func() error {
+			exec++
+			if exec >= 2 {
+				return permanentErr
+			}
+			return testErr
+		},
+			WithTimes(10),
+			WithDelayStrategy(FixedDelay(100*time.Millisecond)),
+			WithOnFailedFunc(func(n int, err error) { onFailedCount++ }),
+			WithRetryIf(func(err error) bool { return !errors.Is(err, permanentErr) }),
+		)
+		duration := time.Since(s)
+		assert.Equal(t, permanentErr, err)
+		assert.Equal(t, 2, exec)
+		assert.Equal(t, 2, onFailedCount)
+		// only the delay after the first retryable error
+		assert.Less(t, duration, 150*time.Millisecond)
+	})
+
+	t.Run("retry all retryable errors", func(t *testing.T) {
+		exec := 0
+		err := Do(context.Background(), func() error {
+			exec++
+			return testErr
+		},
+			WithTimes(3),
+			WithRetryIf(func(err error) bool { return errors.Is(err, testErr) }),
+		)
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 4, exec)
+	})
+}
+
+func TestMaxElapsedTime(t *testing.T) {
+	t.Run("stop before exceeding max elapsed time", func(t *testing.T) {
+		exec := 0
+		s := time.Now()
+		err := Do(context.Background(), func() error {
+			exec++
+			return testErr
+		},
+			WithTimes(10),
+			WithDelayStrategy(FixedDelay(100*time.Millisecond)),
+			WithMaxElapsedTime(250*time.Millisecond),
+		)
+		duration := time.Since(s)
+		assert.Equal(t, testErr, err)
+		// 2 delays fit in 250ms, the 3rd would exceed it
+		assert.Equal(t, 3, exec)
+		assert.Greater(t, duration, 200*time.Millisecond-50*time.Millisecond)
+		assert.Less(t, duration, 200*time.Millisecond+50*time.Millisecond)
+	})
+
+	t.Run("context deadline triggers first", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+		defer cancel()
+		err := Do(ctx, func() error {
+			return testErr
+		},
+			WithTimes(10),
+			WithDelayStrategy(FixedDelay(100*time.Millisecond)),
+			WithMaxElapsedTime(time.Second),
+		)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+}
+
+func TestDoN(t *testing.T) {
+	t.Run("success on first call", func(t *testing.T) {
+		attempts, err := DoN(context.Background(), func() error { return nil }, WithTimes(5))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("success after retry", func(t *testing.T) {
+		attempts, err := DoN(context.Background(), SuccessOnMaxCallFunc(3), WithTimes(5))
+		assert.Nil(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("all failed", func(t *testing.T) {
+		attempts, err := DoN(context.Background(), func() error { return testErr }, WithTimes(5))
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 6, attempts)
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		attempts, err := DoN(ctx, func() error { return nil }, WithTimes(5))
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, 0, attempts)
+	})
+}
+
+func TestFullJitter(t *testing.T) {
+	t.Run("within computed delay", func(t *testing.T) {
+		strategy := FullJitter(ExponentialDelay(100*time.Millisecond, time.Second))
+		for n := 0; n < 10; n++ {
+			delay := strategy(n, testErr)
+			assert.GreaterOrEqual(t, delay, time.Duration(0))
+			assert.Less(t, delay, ExponentialDelay(100*time.Millisecond, time.Second)(n, testErr))
+		}
+	})
+
+	t.Run("zero delay", func(t *testing.T) {
+		strategy := FullJitter(FixedDelay(0))
+		assert.Equal(t, time.Duration(0), strategy(0, testErr))
+	})
+
+	t.Run("deterministic source", func(t *testing.T) {
+		a := FullJitterWithSource(FixedDelay(time.Second), rand.New(rand.NewSource(1)))
+		b := FullJitterWithSource(FixedDelay(time.Second), rand.New(rand.NewSource(1)))
+		for n := 0; n < 10; n++ {
+			assert.Equal(t, a(n, testErr), b(n, testErr))
+		}
+	})
+}
+
+func TestDecorrelatedJitterDelay(t *testing.T) {
+	baseDelay := 100 * time.Millisecond
+	maxDelay := time.Second
+	strategy := DecorrelatedJitterDelay(baseDelay, maxDelay)
+	prev := baseDelay
+	for n := 0; n < 20; n++ {
+		delay := strategy(n, testErr)
+		assert.GreaterOrEqual(t, delay, baseDelay)
+		assert.LessOrEqual(t, delay, maxDelay)
+		assert.LessOrEqual(t, delay, 3*prev)
+		prev = delay
+	}
+}
+
+func TestFibonacciDelay(t *testing.T) {
+	t.Run("fibonacci sequence", func(t *testing.T) {
+		fib := []time.Duration{1, 1, 2, 3, 5, 8, 13, 21, 34, 55}
+		strategy := FibonacciDelay(time.Second, time.Hour)
+		for n, f := range fib {
+			assert.Equal(t, f*time.Second, strategy(n, testErr))
+		}
+	})
+
+	t.Run("capped by max delay", func(t *testing.T) {
+		strategy := FibonacciDelay(time.Second, 10*time.Second)
+		assert.Equal(t, 8*time.Second, strategy(5, testErr))
+		assert.Equal(t, 10*time.Second, strategy(6, testErr))
+		assert.Equal(t, 10*time.Second, strategy(1000, testErr))
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		maxDelay := time.Duration(1<<63 - 1)
+		strategy := FibonacciDelay(time.Hour, maxDelay)
+		assert.Equal(t, maxDelay, strategy(200, testErr))
+	})
+}
+
+func TestScheduleDelay(t *testing.T) {
+	tests := []struct {
+		name   string
+		delays []time.Duration
+		want   []time.Duration
+	}{
+		{name: "schedule", delays: []time.Duration{1, 2, 5, 30}, want: []time.Duration{1, 2, 5, 30, 30, 30}},
+		{name: "single", delays: []time.Duration{3}, want: []time.Duration{3, 3, 3}},
+		{name: "empty", delays: nil, want: []time.Duration{0, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy := ScheduleDelay(test.delays...)
+			for n, want := range test.want {
+				assert.Equal(t, want, strategy(n, testErr))
+			}
+			assert.Equal(t, test.want[len(test.want)-1], strategy(1000, testErr))
+		})
+	}
+
+	t.Run("schedule is copied", func(t *testing.T) {
+		delays := []time.Duration{1, 2}
+		strategy := ScheduleDelay(delays...)
+		delays[0] = 100
+		assert.Equal(t, time.Duration(1), strategy(0, testErr))
+	})
+}
+
+func TestOnSuccess(t *testing.T) {
+	t.Run("success after retry", func(t *testing.T) {
+		var calls []int
+		err := Do(context.Background(), SuccessOnMaxCallFunc(3),
+			WithTimes(5),
+			WithOnSuccessFunc(func(n int) { calls = append(calls, n) }),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, []int{2}, calls)
+	})
+
+	t.Run("break with nil", func(t *testing.T) {
+		var calls []int
+		err := Do(context.Background(), func() error { return Break(nil) },
+			WithTimes(5),
+			WithOnSuccessFunc(func(n int) { calls = append(calls, n) }),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, []int{0}, calls)
+	})
+
+	t.Run("all failed", func(t *testing.T) {
+		called := false
+		err := Do(context.Background(), func() error { return testErr },
+			WithTimes(3),
+			WithOnSuccessFunc(func(n int) { called = true }),
+		)
+		assert.Equal(t, testErr, err)
+		assert.False(t, called)
+	})
+}
+
+func TestRecover(t *testing.T) {
+	t.Run("recover and retry", func(t *testing.T) {
+		recoverCount := 0
+		exec := 0
+		err := Do(context.Background(), func() error {
+			exec++
+			panic("boom")
+		},
+			WithTimes(2),
+			WithRecover(func(r any) error {
+				recoverCount++
+				assert.Equal(t, "boom", r)
+				return testErr
+			}),
+		)
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 3, exec)
+		assert.Equal(t, 3, recoverCount)
+	})
+
+	t.Run("recover then success", func(t *testing.T) {
+		exec := 0
+		err := Do(context.Background(), func() error {
+			exec++
+			if exec < 2 {
+				panic("boom")
+			}
+			return nil
+		},
+			WithTimes(2),
+			WithRecover(func(r any) error { return testErr }),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, exec)
+	})
+
+	t.Run("no recover", func(t *testing.T) {
+		assert.PanicsWithValue(t, "boom", func() {
+			_ = Do(context.Background(), func() error { panic("boom") }, WithTimes(2))
+		})
+	})
+}
+
+func TestShouldRetryOnPanic(t *testing.T) {
+	shouldRetry := func(r any) bool { return r != "bug" }
+	retryIfCount := 0
+	tests := []struct {
+		name     string
+		panicVal any
+		exec     int
+	}{
+		{name: "retry", panicVal: "flaky", exec: 3},
+		{name: "no retry", panicVal: "bug", exec: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			retryIfCount = 0
+			exec := 0
+			err := Do(context.Background(), func() error {
+				exec++
+				panic(test.panicVal)
+			},
+				WithTimes(2),
+				WithRecover(func(r any) error { return testErr }),
+				WithShouldRetryOnPanic(shouldRetry),
+				WithRetryIf(func(err error) bool {
+					retryIfCount++
+					return true
+				}),
+			)
+			assert.ErrorIs(t, err, testErr)
+			assert.Equal(t, test.exec, exec)
+			assert.Equal(t, test.panicVal == "bug", IsUnrecoverable(err))
+			if test.panicVal == "bug" {
+				assert.Equal(t, 0, retryIfCount)
+			}
+		})
+	}
+}
+
+func TestCombineErrors(t *testing.T) {
+	dnsErr := errors.New("dns")
+	timeoutErr := errors.New("timeout")
+	fn := func() func() error {
+		exec := 0
+		return func() error {
+			exec++
+			if exec == 1 {
+				return dnsErr
+			}
+			return timeoutErr
+		}
+	}
+
+	t.Run("combine errors", func(t *testing.T) {
+		err := Do(context.Background(), fn(), WithTimes(3), WithCombineErrors())
+		assert.ErrorIs(t, err, dnsErr)
+		assert.ErrorIs(t, err, timeoutErr)
+		assert.Equal(t, errors.Join(dnsErr, timeoutErr, timeoutErr, timeoutErr), err)
+	})
+
+	t.Run("last error by default", func(t *testing.T) {
+		err := Do(context.Background(), fn(), WithTimes(3))
+		assert.Equal(t, timeoutErr, err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		err := Do(context.Background(), SuccessOnMaxCallFunc(2), WithTimes(3), WithCombineErrors())
+		assert.Nil(t, err)
+	})
+}
+
+func TestAttemptTimeoutFunc(t *testing.T) {
+	t.Run("deadline per attempt", func(t *testing.T) {
+		timeouts := []time.Duration{time.Second, 0, 3 * time.Second}
+		var remaining []time.Duration
+		var hasDeadline []bool
+		_ = DoCtx(context.Background(), func(ctx context.Context) error {
+			deadline, ok := ctx.Deadline()
+			hasDeadline = append(hasDeadline, ok)
+			remaining = append(remaining, time.Until(deadline))
+			return testErr
+		},
+			WithTimes(2),
+			WithAttemptTimeout(time.Hour),
+			WithAttemptTimeoutFunc(func(n int) time.Duration { return timeouts[n] }),
+		)
+		assert.Equal(t, []bool{true, false, true}, hasDeadline)
+		assert.InDelta(t, time.Second, remaining[0], float64(100*time.Millisecond))
+		assert.InDelta(t, 3*time.Second, remaining[2], float64(100*time.Millisecond))
+	})
+
+	t.Run("outer deadline is shorter", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		outer, _ := ctx.Deadline()
+		err := DoCtx(ctx, func(ctx context.Context) error {
+			deadline, _ := ctx.Deadline()
+			assert.Equal(t, outer, deadline)
+			return nil
+		}, WithAttemptTimeoutFunc(func(n int) time.Duration { return time.Hour }))
+		assert.Nil(t, err)
+	})
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	t.Run("retry after attempt timeout", func(t *testing.T) {
+		exec := 0
+		s := time.Now()
+		err := NewConfig(WithTimes(5), WithAttemptTimeout(50*time.Millisecond)).DoCtx(context.Background(), func(ctx context.Context) error {
+			exec++
+			if exec >= 3 {
+				return nil
+			}
+			<-ctx.Done()
+			assert.Equal(t, context.DeadlineExceeded, ctx.Err())
+			return ctx.Err()
+		})
+		duration := time.Since(s)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, exec)
+		assert.Greater(t, duration, 100*time.Millisecond-20*time.Millisecond)
+		assert.Less(t, duration, 100*time.Millisecond+50*time.Millisecond)
+	})
+
+	t.Run("outer context takes precedence", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		exec := 0
+		err := NewConfig(WithTimes(5), WithAttemptTimeout(time.Second)).DoCtx(ctx, func(ctx context.Context) error {
+			exec++
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.Equal(t, 1, exec)
+	})
+}
+
+func TestDoCtx(t *testing.T) {
+	t.Run("forward context", func(t *testing.T) {
+		type key struct{}
+		ctx := context.WithValue(context.Background(), key{}, "value")
+		exec := 0
+		err := DoCtx(ctx, func(ctx context.Context) error {
+			exec++
+			assert.Equal(t, "value", ctx.Value(key{}))
+			if exec >= 2 {
+				return nil
+			}
+			return testErr
+		}, WithTimes(3))
+		assert.Nil(t, err)
+		assert.Equal(t, 2, exec)
+	})
+
+	t.Run("cancel during call", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		err := DoCtx(ctx, func(ctx context.Context) error {
+			cancel()
+			<-ctx.Done()
+			return ctx.Err()
+		}, WithTimes(3))
+		assert.Equal(t, context.Canceled, err)
+	})
+}
+
+func TestDelayStrategyV2(t *testing.T) {
+	t.Run("elapsed grows monotonically", func(t *testing.T) {
+		var elapsedList []time.Duration
+		err := Do(context.Background(), func() error { return testErr },
+			WithTimes(4),
+			WithDelayStrategyV2(func(n int, elapsed time.Duration, err error) time.Duration {
+				assert.Equal(t, len(elapsedList), n)
+				elapsedList = append(elapsedList, elapsed)
+				return 20 * time.Millisecond
+			}),
+		)
+		assert.Equal(t, testErr, err)
+		assert.Len(t, elapsedList, 4)
+		for i := 1; i < len(elapsedList); i++ {
+			assert.Greater(t, elapsedList[i], elapsedList[i-1]+20*time.Millisecond-5*time.Millisecond)
+		}
+	})
+
+	t.Run("takes precedence over DelayStrategy", func(t *testing.T) {
+		called := false
+		err := Do(context.Background(), SuccessOnMaxCallFunc(2),
+			WithTimes(2),
+			WithDelayStrategy(FixedDelay(time.Hour)),
+			WithDelayStrategyV2(func(n int, elapsed time.Duration, err error) time.Duration {
+				called = true
+				return 0
+			}),
+		)
+		assert.Nil(t, err)
+		assert.True(t, called)
+	})
+}
+
+func TestClampDelay(t *testing.T) {
+	for _, testCase := range []struct {
+		name     string
+		minDelay time.Duration
+		maxDelay time.Duration
+		delay    time.Duration
+		expected time.Duration
+	}{
+		{name: "no limit", delay: time.Second, expected: time.Second},
+		{name: "below min", minDelay: time.Second, delay: time.Millisecond, expected: time.Second},
+		{name: "equal to min", minDelay: time.Second, delay: time.Second, expected: time.Second},
+		{name: "zero raised to min", minDelay: time.Second, delay: 0, expected: time.Second},
+		{name: "negative raised to min", minDelay: time.Second, delay: -time.Second, expected: time.Second},
+		{name: "above max", maxDelay: time.Second, delay: time.Minute, expected: time.Second},
+		{name: "equal to max", maxDelay: time.Second, delay: time.Second, expected: time.Second},
+		{name: "overflow", minDelay: time.Millisecond, maxDelay: time.Second, delay: -time.Second, expected: time.Second},
+		{name: "between min and max", minDelay: time.Millisecond, maxDelay: time.Second, delay: 10 * time.Millisecond, expected: 10 * time.Millisecond},
+		{name: "min greater than max", minDelay: time.Minute, maxDelay: time.Second, delay: 0, expected: time.Second},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			config := NewConfig(WithMinDelay(testCase.minDelay), WithMaxDelay(testCase.maxDelay))
+			assert.Equal(t, testCase.expected, config.clampDelay(testCase.delay))
+		})
+	}
+}
+
+func TestMinDelay(t *testing.T) {
+	s := time.Now()
+	err := Do(context.Background(), SuccessOnMaxCallFunc(3),
+		WithTimes(5),
+		WithDelayStrategy(RandomDelay(0, time.Millisecond)),
+		WithMinDelay(50*time.Millisecond),
+	)
+	duration := time.Since(s)
+	assert.Nil(t, err)
+	assert.Greater(t, duration, 2*50*time.Millisecond-10*time.Millisecond)
+	assert.Less(t, duration, 2*50*time.Millisecond+50*time.Millisecond)
+}
+
+func TestMaxDelay(t *testing.T) {
+	for _, testCase := range []struct {
+		name     string
+		strategy DelayStrategy
+		maxDelay time.Duration
+		duration time.Duration
+	}{
+		{
+			name:     "capped",
+			strategy: FixedDelay(time.Hour),
+			maxDelay: 50 * time.Millisecond,
+			duration: 2 * 50 * time.Millisecond,
+		},
+		{
+			name:     "strategy max delay is smaller",
+			strategy: ExponentialDelay(10*time.Millisecond, 20*time.Millisecond),
+			maxDelay: 50 * time.Millisecond,
+			duration: 10*time.Millisecond + 20*time.Millisecond,
+		},
+		{
+			name:     "negative delay",
+			strategy: FixedDelay(-time.Second),
+			maxDelay: 50 * time.Millisecond,
+			duration: 2 * 50 * time.Millisecond,
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			s := time.Now()
+			err := Do(context.Background(), SuccessOnMaxCallFunc(3),
+				WithTimes(5),
+				WithDelayStrategy(testCase.strategy),
+				WithMaxDelay(testCase.maxDelay),
+			)
+			duration := time.Since(s)
+			assert.Nil(t, err)
+			assert.Greater(t, duration, testCase.duration-10*time.Millisecond)
+			assert.Less(t, duration, testCase.duration+50*time.Millisecond)
+		})
+	}
+}
+
+func TestUnrecoverable(t *testing.T) {
+	t.Run("stop on unrecoverable error", func(t *testing.T) {
+		exec := 0
+		onFailedCount := 0
+		err := Do(context.Background(), func() error {
+			exec++
+			return Unrecoverable(testErr)
+		}, WithTimes(10), WithOnFailedFunc(func(n int, err error) { onFailedCount++ }))
+		assert.Equal(t, 1, exec)
+		assert.Equal(t, 1, onFailedCount)
+		assert.True(t, IsUnrecoverable(err))
+		assert.ErrorIs(t, err, testErr)
+		var unrecoverableErr *UnrecoverableError
+		assert.ErrorAs(t, err, &unrecoverableErr)
+		assert.Equal(t, testErr, unrecoverableErr.Err)
+	})
+
+	t.Run("wrapped unrecoverable error", func(t *testing.T) {
+		exec := 0
+		err := Do(context.Background(), func() error {
+			exec++
+			return fmt.Errorf("wrapped: %w", Unrecoverable(testErr))
+		}, WithTimes(10))
+		assert.Equal(t, 1, exec)
+		assert.True(t, IsUnrecoverable(err))
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		assert.Nil(t, Unrecoverable(nil))
+		assert.False(t, IsUnrecoverable(testErr))
+		assert.False(t, IsUnrecoverable(nil))
+	})
+}
+
+func TestNewConfigChecked(t *testing.T) {
+	for _, testCase := range []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{name: "default", opts: []Option{}},
+		{name: "valid", opts: []Option{WithTimes(3), WithMinDelay(time.Second), WithMaxDelay(time.Minute)}},
+		{name: "negative retry times", opts: []Option{WithTimes(-5)}, wantErr: true},
+		{name: "infinite retry times", opts: []Option{WithInfiniteRetry()}},
+		{name: "negative min delay", opts: []Option{WithMinDelay(-time.Second)}, wantErr: true},
+		{name: "negative max delay", opts: []Option{WithMaxDelay(-time.Second)}, wantErr: true},
+		{name: "negative max elapsed time", opts: []Option{WithMaxElapsedTime(-time.Second)}, wantErr: true},
+		{name: "negative attempt timeout", opts: []Option{WithAttemptTimeout(-time.Second)}, wantErr: true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			config, err := NewConfigChecked(testCase.opts...)
+			if testCase.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, config)
+			} else {
+				assert.Nil(t, err)
+				assert.NotNil(t, config)
+			}
+		})
+	}
+}
+
+func TestConfigClone(t *testing.T) {
+	base := NewConfig(
+		WithTimes(3),
+		WithErrorDelayOverride(func(err error) bool { return true }, FixedDelay(time.Second)),
+	)
+
+	t.Run("clone", func(t *testing.T) {
+		clone := base.Clone()
+		clone.RetryTimes = 5
+		clone.DelayOverrides[0].Strategy = FixedDelay(time.Minute)
+		assert.Equal(t, 3, base.RetryTimes)
+		assert.Equal(t, time.Second, base.DelayOverrides[0].Strategy(0, testErr))
+	})
+
+	t.Run("with", func(t *testing.T) {
+		derived := base.With(WithTimes(10), WithErrorDelayOverride(func(err error) bool { return true }, FixedDelay(time.Hour)))
+		assert.Equal(t, 10, derived.RetryTimes)
+		assert.Len(t, derived.DelayOverrides, 2)
+		assert.Equal(t, 3, base.RetryTimes)
+		assert.Len(t, base.DelayOverrides, 1)
+
+		attempts, err := derived.With(WithDelayStrategy(FixedDelay(0)), WithMaxDelay(time.Nanosecond)).
+			DoN(context.Background(), func() error { return testErr })
+		assert.Equal(t, 11, attempts)
+		assert.Equal(t, testErr, err)
+	})
+
+	t.Run("with rand source", func(t *testing.T) {
+		strategy := NewRandStrategy(func(r *rand.Rand) DelayStrategy {
+			return RandomDelayWithSource(0, time.Second, r)
+		})
+		derived := NewConfig(WithRandAwareDelayStrategy(strategy)).With(WithRandSource(rand.New(rand.NewSource(1))))
+		want := RandomDelayWithSource(0, time.Second, rand.New(rand.NewSource(1)))(0, testErr)
+		assert.Equal(t, want, derived.DelayStrategy(0, testErr))
+	})
+
+	t.Run("rand source does not affect base", func(t *testing.T) {
+		newStrategy := func() *RandStrategy {
+			return NewRandStrategy(func(r *rand.Rand) DelayStrategy {
+				return RandomDelayWithSource(0, time.Second, r)
+			})
+		}
+		base := NewConfig(WithRandAwareDelayStrategy(newStrategy()), WithRandSource(rand.New(rand.NewSource(1))))
+		base.With(WithRandSource(rand.New(rand.NewSource(2))))
+		want := NewConfig(WithRandAwareDelayStrategy(newStrategy()), WithRandSource(rand.New(rand.NewSource(1))))
+		for n := 0; n < 5; n++ {
+			assert.Equal(t, want.DelayStrategy(n, testErr), base.DelayStrategy(n, testErr))
+		}
+	})
+
+	t.Run("base and derived run concurrently", func(t *testing.T) {
+		base := NewConfig(WithRandAwareDelayStrategy(NewRandStrategy(func(r *rand.Rand) DelayStrategy {
+			return RandomDelayWithSource(0, time.Second, r)
+		})), WithRandSource(rand.New(rand.NewSource(1))))
+		derived := base.With(WithRandSource(rand.New(rand.NewSource(2))))
+		var wg sync.WaitGroup
+		for _, config := range []*Config{base, derived} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for n := 0; n < 100; n++ {
+					config.DelayStrategy(n, testErr)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func TestRetryer(t *testing.T) {
+	t.Run("build", func(t *testing.T) {
+		config := NewRetryer().Times(5).MinDelay(time.Second).MaxDelay(time.Minute).Build()
+		assert.Equal(t, 5, config.RetryTimes)
+		assert.Equal(t, time.Second, config.MinDelay)
+		assert.Equal(t, time.Minute, config.MaxDelay)
+	})
+
+	t.Run("do", func(t *testing.T) {
+		onRetryCount := 0
+		onFailedCount := 0
+		retryer := NewRetryer().
+			Times(3).
+			Delay(FixedDelay(10 * time.Millisecond)).
+			OnRetry(func(n int) { onRetryCount++ }).
+			OnFailed(func(n int, err error) { onFailedCount++ })
+		err := retryer.Do(context.Background(), SuccessOnMaxCallFunc(3))
+		assert.Nil(t, err)
+		assert.Equal(t, 2, onRetryCount)
+		assert.Equal(t, 2, onFailedCount)
+	})
+
+	t.Run("derive", func(t *testing.T) {
+		base := NewRetryer().Times(3)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.Equal(t, 10+i, base.Times(10+i).Build().RetryTimes)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 3, base.Build().RetryTimes)
+	})
+}
+
+func TestConcurrentDo(t *testing.T) {
+	config := NewConfig(WithTimes(3))
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := config.Do(context.Background(), SuccessOnMaxCallFunc(2))
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Nil(t, config.OnRetry)
+	assert.Nil(t, config.OnFailed)
+	assert.Nil(t, config.DelayStrategy)
+}
+
+func TestLinearDelayWithStep(t *testing.T) {
+	t.Run("initial and step", func(t *testing.T) {
+		strategy := LinearDelayWithStep(2*time.Second, 5*time.Second, 20*time.Second)
+		want := []time.Duration{2, 7, 12, 17, 20, 20}
+		for n, w := range want {
+			assert.Equal(t, w*time.Second, strategy(n, testErr))
+		}
+	})
+
+	t.Run("same as LinearDelay", func(t *testing.T) {
+		strategy := LinearDelayWithStep(time.Second, time.Second, 10*time.Second)
+		linear := LinearDelay(time.Second, 10*time.Second)
+		for n := 0; n < 20; n++ {
+			assert.Equal(t, linear(n, testErr), strategy(n, testErr))
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		maxDelay := time.Duration(math.MaxInt64)
+		strategy := LinearDelayWithStep(time.Hour, time.Hour, maxDelay)
+		assert.Equal(t, maxDelay, strategy(math.MaxInt-1, testErr))
+		strategy = LinearDelayWithStep(time.Second, math.MaxInt64/2, maxDelay)
+		assert.Equal(t, maxDelay, strategy(3, testErr))
+	})
+
+	t.Run("zero step", func(t *testing.T) {
+		strategy := LinearDelayWithStep(time.Second, 0, time.Minute)
+		assert.Equal(t, time.Second, strategy(100, testErr))
+	})
+}
+
+func TestTaperedDelay(t *testing.T) {
+	strategy := TaperedDelay(time.Second, 11*time.Second, 10)
+	assert.Equal(t, time.Second, strategy(0, testErr))
+	assert.Equal(t, 2*time.Second, strategy(1, testErr))
+	assert.Equal(t, 6*time.Second, strategy(5, testErr))
+	assert.Equal(t, 11*time.Second, strategy(10, testErr))
+	assert.Equal(t, 11*time.Second, strategy(100, testErr))
+
+	assert.Equal(t, 11*time.Second, TaperedDelay(time.Second, 11*time.Second, 0)(0, testErr))
+}
+
+func TestExponentialDelayForBudget(t *testing.T) {
+	tests := []struct {
+		times  int
+		budget time.Duration
+	}{
+		{times: 10, budget: 2 * time.Minute},
+		{times: 1, budget: time.Second},
+		{times: 5, budget: 31 * time.Second},
+		{times: 100, budget: time.Hour},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d times in %v", test.times, test.budget), func(t *testing.T) {
+			strategy := ExponentialDelayForBudget(test.times, test.budget)
+			var sum time.Duration
+			for n := 0; n < test.times; n++ {
+				delay := strategy(n, testErr)
+				if n > 0 {
+					assert.InDelta(t, 2*float64(strategy(n-1, testErr)), float64(delay), 1)
+				}
+				sum += delay
+			}
+			assert.LessOrEqual(t, sum, test.budget)
+			assert.Less(t, test.budget-sum, time.Duration(test.times))
+			assert.Equal(t, strategy(test.times-1, testErr), strategy(test.times+10, testErr))
+		})
+	}
+
+	t.Run("exact", func(t *testing.T) {
+		strategy := ExponentialDelayForBudget(5, 31*time.Second)
+		assert.Equal(t, time.Second, strategy(0, testErr))
+		assert.Equal(t, 16*time.Second, strategy(4, testErr))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		assert.Equal(t, time.Duration(0), ExponentialDelayForBudget(0, time.Minute)(0, testErr))
+		assert.Equal(t, time.Duration(0), ExponentialDelayForBudget(5, -time.Minute)(0, testErr))
+	})
+}
+
+func TestExponentialDelayWithReset(t *testing.T) {
+	t.Run("sawtooth", func(t *testing.T) {
+		strategy := ExponentialDelayWithReset(time.Second, 8*time.Second)
+		want := []time.Duration{1, 2, 4, 8, 1, 2, 4, 8, 1, 2, 4, 8}
+		for n, w := range want {
+			assert.Equal(t, w*time.Second, strategy(n, testErr))
+		}
+	})
+
+	t.Run("reset on new do", func(t *testing.T) {
+		strategy := ExponentialDelayWithReset(time.Second, 8*time.Second)
+		assert.Equal(t, time.Second, strategy(0, testErr))
+		assert.Equal(t, 2*time.Second, strategy(1, testErr))
+		assert.Equal(t, time.Second, strategy(0, testErr))
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		maxDelay := time.Duration(math.MaxInt64)
+		strategy := ExponentialDelayWithReset(3, maxDelay)
+		for n := 0; n < 200; n++ {
+			delay := strategy(n, testErr)
+			assert.True(t, delay > 0 && delay <= maxDelay)
+		}
+	})
+
+	t.Run("base above max", func(t *testing.T) {
+		strategy := ExponentialDelayWithReset(10*time.Second, time.Second)
+		assert.Equal(t, time.Second, strategy(0, testErr))
+		assert.Equal(t, time.Second, strategy(1, testErr))
+	})
+}
+
+func TestExponentialDelayOverflow(t *testing.T) {
+	tests := []struct {
+		name      string
+		baseDelay time.Duration
+		n         int
+		want      time.Duration
+	}{
+		{name: "shift overflow", baseDelay: time.Second, n: 40, want: time.Hour},
+		{name: "shift past width", baseDelay: time.Millisecond, n: 64, want: time.Hour},
+		{name: "large n", baseDelay: 1, n: 1000, want: time.Hour},
+		{name: "zero base", baseDelay: 0, n: 100, want: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, ExponentialDelay(test.baseDelay, time.Hour)(test.n, testErr))
+		})
+	}
+}
+
+func TestExponentialDelayWithFactor(t *testing.T) {
+	t.Run("factor 2 equals ExponentialDelay", func(t *testing.T) {
+		a := ExponentialDelayWithFactor(100*time.Millisecond, time.Minute, 2)
+		b := ExponentialDelay(100*time.Millisecond, time.Minute)
+		for n := 0; n < 20; n++ {
+			assert.Equal(t, b(n, testErr), a(n, testErr))
+		}
+	})
+
+	t.Run("factor 1 is constant", func(t *testing.T) {
+		strategy := ExponentialDelayWithFactor(time.Second, time.Minute, 1)
+		for n := 0; n < 10; n++ {
+			assert.Equal(t, time.Second, strategy(n, testErr))
+		}
+	})
+
+	t.Run("fractional factor", func(t *testing.T) {
+		strategy := ExponentialDelayWithFactor(time.Second, time.Minute, 1.5)
+		for n, expected := range []time.Duration{
+			time.Second,
+			1500 * time.Millisecond,
+			2250 * time.Millisecond,
+			3375 * time.Millisecond,
+		} {
+			assert.Equal(t, expected, strategy(n, testErr))
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		strategy := ExponentialDelayWithFactor(time.Second, time.Minute, 3)
+		assert.Equal(t, time.Minute, strategy(5, testErr))
+		assert.Equal(t, time.Minute, strategy(10000, testErr))
+	})
+}
+
+func TestRetryBudget(t *testing.T) {
+	t.Run("shared budget exhausted", func(t *testing.T) {
+		budget := NewRetryBudget(2, 0)
+		attempts, err := DoN(context.Background(), func() error { return testErr }, WithTimes(5), WithBudget(budget))
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 3, attempts)
+		attempts, err = DoN(context.Background(), func() error { return testErr }, WithTimes(5), WithBudget(budget))
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("refill", func(t *testing.T) {
+		budget := NewRetryBudget(1, 100)
+		assert.True(t, budget.Allow())
+		assert.False(t, budget.Allow())
+		time.Sleep(20 * time.Millisecond)
+		assert.True(t, budget.Allow())
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		budget := NewRetryBudget(50, 0)
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		allowed := 0
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if budget.Allow() {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 50, allowed)
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Run("open after consecutive failures", func(t *testing.T) {
+		cb := NewConsecutiveFailureBreaker(3, time.Hour)
+		attempts, err := DoN(context.Background(), func() error { return testErr }, WithTimes(5), WithCircuitBreaker(cb))
+		assert.Equal(t, ErrCircuitOpen, err)
+		assert.Equal(t, 3, attempts)
+
+		attempts, err = DoN(context.Background(), func() error { return nil }, WithCircuitBreaker(cb))
+		assert.Equal(t, ErrCircuitOpen, err)
+		assert.Equal(t, 0, attempts)
+	})
+
+	t.Run("half open", func(t *testing.T) {
+		cb := NewConsecutiveFailureBreaker(1, 20*time.Millisecond)
+		cb.Report(false)
+		assert.False(t, cb.Allow())
+		time.Sleep(30 * time.Millisecond)
+		assert.True(t, cb.Allow())
+		assert.False(t, cb.Allow())
+		cb.Report(true)
+		assert.True(t, cb.Allow())
+		assert.True(t, cb.Allow())
+	})
+
+	t.Run("success resets failures", func(t *testing.T) {
+		cb := NewConsecutiveFailureBreaker(3, time.Hour)
+		err := Do(context.Background(), SuccessOnMaxCallFunc(3), WithTimes(5), WithCircuitBreaker(cb))
+		assert.Nil(t, err)
+		err = Do(context.Background(), SuccessOnMaxCallFunc(3), WithTimes(5), WithCircuitBreaker(cb))
+		assert.Nil(t, err)
+	})
+}
+
+func TestDoAll(t *testing.T) {
+	fns := func() []func() error {
+		return []func() error{
+			SuccessOnMaxCallFunc(1),
+			func() error { return testErr },
+			SuccessOnMaxCallFunc(3),
+		}
+	}
+
+	t.Run("sequential", func(t *testing.T) {
+		errs := DoAll(context.Background(), fns(), WithTimes(3))
+		assert.Equal(t, []error{nil, testErr, nil}, errs)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		errs := DoAll(context.Background(), fns(), WithTimes(3), WithConcurrency(2))
+		assert.Equal(t, []error{nil, testErr, nil}, errs)
+	})
+
+	t.Run("concurrency limit", func(t *testing.T) {
+		var mu sync.Mutex
+		running, maxRunning := 0, 0
+		fns := make([]func() error, 10)
+		for i := range fns {
+			fns[i] = func() error {
+				mu.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				mu.Lock()
+				running--
+				mu.Unlock()
+				return nil
+			}
+		}
+		errs := DoAll(context.Background(), fns, WithConcurrency(3))
+		assert.Equal(t, make([]error, 10), errs)
+		assert.Equal(t, 3, maxRunning)
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		exec := 0
+		errs := DoAll(ctx, []func() error{
+			func() error {
+				exec++
+				cancel()
+				return nil
+			},
+			func() error {
+				exec++
+				return nil
+			},
+		})
+		assert.Equal(t, []error{nil, context.Canceled}, errs)
+		assert.Equal(t, 1, exec)
+	})
+
+	t.Run("order preserved", func(t *testing.T) {
+		errs := make([]error, 5)
+		fns := make([]func() error, 5)
+		for i := range fns {
+			errs[i] = fmt.Errorf("fn %d", i)
+			fns[i] = func(i int) func() error {
+				return func() error {
+					time.Sleep(time.Duration(5-i) * 5 * time.Millisecond)
+					return errs[i]
+				}
+			}(i)
+		}
+		assert.Equal(t, errs, DoAll(context.Background(), fns, WithConcurrency(Infinite)))
+	})
+
+	t.Run("unbounded", func(t *testing.T) {
+		var wg sync.WaitGroup
+		wg.Add(10)
+		fns := make([]func() error, 10)
+		for i := range fns {
+			fns[i] = func() error {
+				wg.Done()
+				wg.Wait()
+				return nil
+			}
+		}
+		assert.Equal(t, make([]error, 10), DoAll(context.Background(), fns, WithConcurrency(Infinite)))
+	})
+}
+
+func TestDoAllCtx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{}, 2)
+	fn := func(ctx context.Context) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	go func() {
+		<-started
+		<-started
+		cancel()
+	}()
+	errs := DoAllCtx(ctx, []func(ctx context.Context) error{fn, fn, fn}, WithTimes(3), WithConcurrency(2))
+	assert.Equal(t, []error{context.Canceled, context.Canceled, context.Canceled}, errs)
+}
+
+func TestGroup(t *testing.T) {
+	t.Run("all succeed", func(t *testing.T) {
+		g, ctx := NewGroup(context.Background(), WithTimes(3))
+		for i := 0; i < 3; i++ {
+			g.Go(SuccessOnMaxCallFunc(3))
+		}
+		assert.Nil(t, g.Wait())
+		assert.Equal(t, context.Canceled, ctx.Err())
+	})
+
+	t.Run("first permanent failure cancels the rest", func(t *testing.T) {
+		g, ctx := NewGroup(context.Background(), WithTimes(3), WithDelayStrategy(FixedDelay(10*time.Millisecond)))
+		var mu sync.Mutex
+		slowAttempts := 0
+		g.Go(func() error { return testErr })
+		g.Go(func() error {
+			mu.Lock()
+			slowAttempts++
+			mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Hour):
+				return nil
+			}
+		})
+		err := g.Wait()
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, testErr, context.Cause(ctx))
+		assert.Equal(t, 1, slowAttempts)
+	})
+}
+
+func TestDoFirst(t *testing.T) {
+	dnsErr := errors.New("dns")
+	t.Run("failover", func(t *testing.T) {
+		var calls []int
+		err := DoFirst(context.Background(), []func() error{
+			func() error { calls = append(calls, 0); return testErr },
+			func() error { calls = append(calls, 1); return nil },
+			func() error { calls = append(calls, 2); return nil },
+		}, WithTimes(1))
+		assert.Nil(t, err)
+		assert.Equal(t, []int{0, 0, 1}, calls)
+	})
+
+	t.Run("all failed", func(t *testing.T) {
+		err := DoFirst(context.Background(), []func() error{
+			func() error { return testErr },
+			func() error { return dnsErr },
+		}, WithTimes(1))
+		assert.ErrorIs(t, err, testErr)
+		assert.ErrorIs(t, err, dnsErr)
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		exec := 0
+		err := DoFirst(ctx, []func() error{
+			func() error { exec++; cancel(); return testErr },
+			func() error { exec++; return nil },
+		}, WithTimes(2))
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, exec)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		assert.Nil(t, DoFirst(context.Background(), nil))
+	})
+}
+
+func TestPreserveLastError(t *testing.T) {
+	t.Run("preserve last error", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := Do(ctx, func() error { return testErr },
+			WithTimes(10),
+			WithDelayStrategy(FixedDelay(time.Second)),
+			WithPreserveLastError(),
+		)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorIs(t, err, testErr)
+	})
+
+	t.Run("context error by default", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := Do(ctx, func() error { return testErr },
+			WithTimes(10),
+			WithDelayStrategy(FixedDelay(time.Second)),
+		)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+
+	t.Run("canceled before first call", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := Do(ctx, func() error { return testErr }, WithPreserveLastError())
+		assert.Equal(t, context.Canceled, err)
+	})
+}
+
+func TestContextErrorWrapping(t *testing.T) {
+	t.Run("canceled after retries", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		exec := 0
+		err := Do(ctx, func() error {
+			exec++
+			if exec == 3 {
+				cancel()
+			}
+			return testErr
+		},
+			WithTimes(10),
+			WithContextErrorWrapping(),
+		)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.ErrorIs(t, err, testErr)
+		var ctxErr *ContextError
+		assert.ErrorAs(t, err, &ctxErr)
+		assert.Equal(t, 3, ctxErr.Attempts())
+		assert.Equal(t, testErr, ctxErr.LastErr)
+		assert.Equal(t, "retry: context canceled after 3 attempts, last error: test", err.Error())
+	})
+
+	t.Run("canceled before first call", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := Do(ctx, func() error { return testErr }, WithContextErrorWrapping())
+		assert.ErrorIs(t, err, context.Canceled)
+		var ctxErr *ContextError
+		assert.ErrorAs(t, err, &ctxErr)
+		assert.Equal(t, 0, ctxErr.Attempts())
+		assert.Nil(t, ctxErr.LastErr)
+		assert.Equal(t, "retry: context canceled after 0 attempts", err.Error())
+	})
+}
+
+func TestStopDelay(t *testing.T) {
+	t.Run("stop by strategy", func(t *testing.T) {
+		attempts, err := DoN(context.Background(), func() error { return testErr },
+			WithTimes(10),
+			WithDelayStrategy(func(n int, err error) time.Duration {
+				if n >= 2 {
+					return StopDelay
+				}
+				return 0
+			}),
+			WithMaxDelay(time.Second),
+		)
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("full jitter keeps stop delay", func(t *testing.T) {
+		strategy := FullJitter(func(n int, err error) time.Duration { return StopDelay })
+		assert.Equal(t, StopDelay, strategy(0, testErr))
+	})
+}
+
+func TestEventChannel(t *testing.T) {
+	t.Run("events", func(t *testing.T) {
+		ch := make(chan RetryEvent, 10)
+		err := Do(context.Background(), SuccessOnMaxCallFunc(3),
+			WithTimes(1),
+			WithDelayStrategy(FixedDelay(10*time.Millisecond)),
+			WithEventChannel(ch),
+		)
+		close(ch)
+		assert.Equal(t, testErr, err)
+		var events []RetryEvent
+		for event := range ch {
+			assert.False(t, event.Time.IsZero())
+			event.Time = time.Time{}
+			events = append(events, event)
+		}
+		assert.Equal(t, []RetryEvent{
+			{Type: EventFailed, Attempt: 0, Err: testErr, Delay: 10 * time.Millisecond},
+			{Type: EventRetry, Attempt: 1, Err: testErr, Delay: 10 * time.Millisecond},
+			{Type: EventFailed, Attempt: 1, Err: testErr, Delay: 0},
+		}, events)
+	})
+
+	t.Run("full channel does not block", func(t *testing.T) {
+		ch := make(chan RetryEvent)
+		attempts, err := DoN(context.Background(), func() error { return testErr },
+			WithTimes(3),
+			WithEventChannel(ch),
+		)
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 4, attempts)
+	})
+}
+
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, args ...any) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestLogger(t *testing.T) {
+	t.Run("log failures", func(t *testing.T) {
+		logger := &testLogger{}
+		err := Do(context.Background(), func() error { return testErr },
+			WithTimes(1),
+			WithDelayStrategy(FixedDelay(time.Millisecond)),
+			WithLogger(logger),
+		)
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, []string{
+			"retry: attempt 0 failed: test, retrying in 1ms",
+			"retry: giving up after 2 attempts: test",
+		}, logger.lines)
+	})
+
+	t.Run("slog", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := Do(context.Background(), func() error { return testErr },
+			WithLogger(SlogLogger(slog.New(slog.NewTextHandler(&buf, nil)))),
+		)
+		assert.Equal(t, testErr, err)
+		assert.Contains(t, buf.String(), "retry: giving up after 1 attempts: test")
+	})
+
+	t.Run("nil logger", func(t *testing.T) {
+		err := Do(context.Background(), func() error { return testErr }, WithTimes(1), WithLogger(nil))
+		assert.Equal(t, testErr, err)
+	})
+}
+
+func TestInitialDelay(t *testing.T) {
+	t.Run("initial delay plus retry delays", func(t *testing.T) {
+		var onRetryList []int
+		s := time.Now()
+		err := Do(context.Background(), SuccessOnMaxCallFunc(3),
+			WithTimes(5),
+			WithInitialDelay(100*time.Millisecond),
+			WithDelayStrategy(FixedDelay(50*time.Millisecond)),
+			WithOnRetryFunc(func(n int) { onRetryList = append(onRetryList, n) }),
+		)
+		duration := time.Since(s)
+		assert.Nil(t, err)
+		assert.Equal(t, []int{1, 2}, onRetryList)
+		expected := 100*time.Millisecond + 2*50*time.Millisecond
+		assert.Greater(t, duration, expected-10*time.Millisecond)
+		assert.Less(t, duration, expected+50*time.Millisecond)
+	})
+
+	t.Run("canceled during initial delay", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		attempts, err := DoN(ctx, func() error { return nil }, WithInitialDelay(time.Second))
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.Equal(t, 0, attempts)
+	})
+}
+
+func TestExponentialDelayWithJitter(t *testing.T) {
+	t.Run("within jitter range", func(t *testing.T) {
+		exponential := ExponentialDelay(100*time.Millisecond, time.Minute)
+		strategy := ExponentialDelayWithJitter(100*time.Millisecond, time.Minute, 0.2)
+		for n := 0; n < 10; n++ {
+			delay := strategy(n, testErr)
+			expected := exponential(n, testErr)
+			assert.GreaterOrEqual(t, delay, expected*8/10)
+			assert.LessOrEqual(t, delay, expected*12/10)
+		}
+	})
+
+	t.Run("zero jitter equals ExponentialDelay", func(t *testing.T) {
+		exponential := ExponentialDelay(100*time.Millisecond, time.Minute)
+		strategy := ExponentialDelayWithJitter(100*time.Millisecond, time.Minute, 0)
+		for n := 0; n < 20; n++ {
+			assert.Equal(t, exponential(n, testErr), strategy(n, testErr))
+		}
+	})
+
+	t.Run("never negative", func(t *testing.T) {
+		strategy := Jitter(FixedDelay(time.Second), 5)
+		for n := 0; n < 100; n++ {
+			assert.GreaterOrEqual(t, strategy(n, testErr), time.Duration(0))
+		}
+	})
+
+	t.Run("deterministic source", func(t *testing.T) {
+		a := JitterWithSource(ExponentialDelay(100*time.Millisecond, time.Minute), 0.2, rand.New(rand.NewSource(1)))
+		b := JitterWithSource(ExponentialDelay(100*time.Millisecond, time.Minute), 0.2, rand.New(rand.NewSource(1)))
+		for n := 0; n < 10; n++ {
+			assert.Equal(t, a(n, testErr), b(n, testErr))
+		}
+	})
+}
+
+func TestUntilConsecutiveSuccess(t *testing.T) {
+	results := func(errs ...error) func() error {
+		exec := 0
+		return func() error {
+			err := errs[exec]
+			exec++
+			return err
+		}
+	}
+
+	t.Run("consecutive successes", func(t *testing.T) {
+		attempts, err := DoN(context.Background(), results(nil, testErr, nil, nil, nil), WithTimes(10), WithUntilConsecutiveSuccess(3))
+		assert.Nil(t, err)
+		assert.Equal(t, 5, attempts)
+	})
+
+	t.Run("reset on error", func(t *testing.T) {
+		attempts, err := DoN(context.Background(), results(nil, nil, testErr, nil, nil), WithTimes(4), WithUntilConsecutiveSuccess(3))
+		assert.Equal(t, ErrNotEnoughSuccesses, err)
+		assert.Equal(t, 5, attempts)
+	})
+
+	t.Run("exhausted on error", func(t *testing.T) {
+		attempts, err := DoN(context.Background(), results(nil, nil, testErr), WithTimes(2), WithUntilConsecutiveSuccess(3))
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("default stops on first success", func(t *testing.T) {
+		attempts, err := DoN(context.Background(), results(nil), WithTimes(2))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("stop delay", func(t *testing.T) {
+		attempts, err := DoN(context.Background(), results(nil, nil, nil), WithTimes(10), WithUntilConsecutiveSuccess(3),
+			WithDelayStrategy(func(n int, err error) time.Duration {
+				if n >= 1 {
+					return StopDelay
+				}
+				return 0
+			}))
+		assert.Equal(t, ErrNotEnoughSuccesses, err)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("max elapsed time", func(t *testing.T) {
+		clock := retrytest.NewFakeClock(time.Now())
+		done := make(chan Result)
+		go func() {
+			done <- DoResult(context.Background(), func() error { return nil },
+				WithTimes(10),
+				WithUntilConsecutiveSuccess(10),
+				WithDelayStrategy(FixedDelay(time.Hour)),
+				WithMaxElapsedTime(150*time.Minute),
+				WithClock(clock),
+			)
+		}()
+		for i := 1; i <= 2; i++ {
+			clock.BlockUntil(1)
+			clock.Advance(time.Hour)
+		}
+		result := <-done
+		assert.Equal(t, ErrNotEnoughSuccesses, result.Err)
+		assert.Equal(t, 3, result.Attempts)
+	})
+
+	t.Run("budget", func(t *testing.T) {
+		budget := NewRetryBudget(1, 0)
+		attempts, err := DoN(context.Background(), results(nil, nil, nil), WithTimes(10), WithUntilConsecutiveSuccess(3), WithBudget(budget))
+		assert.Equal(t, ErrNotEnoughSuccesses, err)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("continue if", func(t *testing.T) {
+		attempts, err := DoN(context.Background(), results(nil, nil, nil), WithTimes(10), WithUntilConsecutiveSuccess(3),
+			WithContinueIf(func() bool { return false }))
+		assert.Equal(t, ErrNotEnoughSuccesses, err)
+		assert.Equal(t, 1, attempts)
+	})
+}
+
+type retryAfterError struct {
+	retryAfter time.Duration
+}
+
+func (e retryAfterError) Error() string {
+	return "retry after"
+}
+
+func (e retryAfterError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+func TestRetryAfterDelay(t *testing.T) {
+	strategy := RetryAfterDelay(FixedDelay(time.Second))
+	assert.Equal(t, 5*time.Second, strategy(0, retryAfterError{5 * time.Second}))
+	assert.Equal(t, 5*time.Second, strategy(0, fmt.Errorf("wrapped: %w", retryAfterError{5 * time.Second})))
+	assert.Equal(t, time.Second, strategy(0, testErr))
+	assert.Equal(t, time.Second, strategy(0, nil))
+
+	exec := 0
+	s := time.Now()
+	err := Do(context.Background(), func() error {
+		exec++
+		if exec >= 2 {
+			return nil
+		}
+		return retryAfterError{50 * time.Millisecond}
+	}, WithTimes(3), WithDelayStrategy(RetryAfterDelay(FixedDelay(time.Second))))
+	duration := time.Since(s)
+	assert.Nil(t, err)
+	assert.Greater(t, duration, 50*time.Millisecond-10*time.Millisecond)
+	assert.Less(t, duration, 50*time.Millisecond+50*time.Millisecond)
+}
+
+func TestAttemptFromContext(t *testing.T) {
+	var attempts []int
+	err := DoCtx(context.Background(), func(ctx context.Context) error {
+		attempts = append(attempts, AttemptFromContext(ctx))
+		return testErr
+	}, WithTimes(3))
+	assert.Equal(t, testErr, err)
+	assert.Equal(t, []int{0, 1, 2, 3}, attempts)
+	assert.Equal(t, 0, AttemptFromContext(context.Background()))
+}
+
+func TestBeforeAfterAttempt(t *testing.T) {
+	dialErr := errors.New("dial")
+	var calls []string
+	exec := 0
+	err := Do(context.Background(), func() error {
+		exec++
+		calls = append(calls, fmt.Sprintf("fn %d", exec))
+		if exec < 2 {
+			return testErr
+		}
+		return nil
+	},
+		WithTimes(3),
+		WithBeforeAttempt(func(n int) error {
+			calls = append(calls, fmt.Sprintf("before %d", n))
+			if n == 1 {
+				return dialErr
+			}
+			return nil
+		}),
+		WithAfterAttempt(func(n int, err error) {
+			calls = append(calls, fmt.Sprintf("after %d %v", n, err))
+		}),
+		WithOnFailedFunc(func(n int, err error) {
+			calls = append(calls, fmt.Sprintf("failed %d %v", n, err))
+		}),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"before 0", "fn 1", "after 0 test", "failed 0 test",
+		"before 1", "failed 1 dial",
+		"before 2", "fn 2", "after 2 <nil>",
+	}, calls)
+
+	t.Run("after attempt sees recovered panic", func(t *testing.T) {
+		var afterErr error
+		_ = Do(context.Background(), func() error { panic("boom") },
+			WithRecover(func(r any) error { return testErr }),
+			WithAfterAttempt(func(n int, err error) { afterErr = err }),
+		)
+		assert.Equal(t, testErr, afterErr)
+	})
+}
+
+func TestOnRetryErrOnFailedErr(t *testing.T) {
+	stopErr := errors.New("stop")
+
+	t.Run("on retry err", func(t *testing.T) {
+		var giveUpAttempts int
+		attempts, err := DoN(context.Background(), func() error { return testErr },
+			WithTimes(5),
+			WithOnRetryErr(func(n int) error {
+				if n == 2 {
+					return stopErr
+				}
+				return nil
+			}),
+			WithOnGiveUpFunc(func(attempts int, err error) { giveUpAttempts = attempts }),
+		)
+		assert.Equal(t, stopErr, err)
+		assert.Equal(t, 2, attempts)
+		assert.Equal(t, 2, giveUpAttempts)
+	})
+
+	t.Run("on failed err", func(t *testing.T) {
+		var failedCalls int
+		attempts, err := DoN(context.Background(), func() error { return testErr },
+			WithTimes(5),
+			WithOnFailedFunc(func(n int, err error) { failedCalls++ }),
+			WithOnFailedErr(func(n int, err error) error {
+				if n == 1 {
+					return fmt.Errorf("%w: %w", stopErr, err)
+				}
+				return nil
+			}),
+		)
+		assert.ErrorIs(t, err, stopErr)
+		assert.ErrorIs(t, err, testErr)
+		assert.Equal(t, 2, attempts)
+		assert.Equal(t, 0, failedCalls)
+	})
+
+	t.Run("nil keeps retrying", func(t *testing.T) {
+		var retries []int
+		attempts, err := DoN(context.Background(), func() error { return testErr },
+			WithTimes(2),
+			WithOnRetryErr(func(n int) error { retries = append(retries, n); return nil }),
+			WithOnFailedErr(func(n int, err error) error { return nil }),
+		)
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 3, attempts)
+		assert.Equal(t, []int{1, 2}, retries)
+	})
+}
+
+func TestOnGiveUp(t *testing.T) {
+	type giveUp struct {
+		attempts int
+		err      error
+	}
+	for _, testCase := range []struct {
+		name     string
+		ctxFunc  func() (context.Context, context.CancelFunc)
+		fn       func() error
+		opts     []Option
+		expected []giveUp
+	}{
+		{
+			name:     "exhausted",
+			fn:       func() error { return testErr },
+			opts:     []Option{WithTimes(2)},
+			expected: []giveUp{{3, testErr}},
+		},
+		{
+			name:     "break",
+			fn:       func() error { return Break(testErr) },
+			opts:     []Option{WithTimes(2)},
+			expected: []giveUp{{1, testErr}},
+		},
+		{
+			name:     "retry if",
+			fn:       func() error { return testErr },
+			opts:     []Option{WithTimes(2), WithRetryIf(func(err error) bool { return false })},
+			expected: []giveUp{{1, testErr}},
+		},
+		{
+			name: "success",
+			fn:   SuccessOnMaxCallFunc(2),
+			opts: []Option{WithTimes(2)},
+		},
+		{
+			name: "context canceled",
+			ctxFunc: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 20*time.Millisecond)
+			},
+			fn:   func() error { return testErr },
+			opts: []Option{WithTimes(2), WithDelayStrategy(FixedDelay(time.Second))},
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if testCase.ctxFunc != nil {
+				ctx, cancel = testCase.ctxFunc()
+			}
+			defer cancel()
+			var giveUps []giveUp
+			_ = Do(ctx, testCase.fn, append(testCase.opts, WithOnGiveUpFunc(func(attempts int, err error) {
+				giveUps = append(giveUps, giveUp{attempts, err})
+			}))...)
+			assert.Equal(t, testCase.expected, giveUps)
+		})
+	}
+}
+
+func TestInfiniteRetry(t *testing.T) {
+	t.Run("stop by break", func(t *testing.T) {
+		attempts, err := DoN(context.Background(), func() func() error {
+			exec := 0
+			return func() error {
+				exec++
+				if exec >= 100 {
+					return Break(testErr)
+				}
+				return testErr
+			}
+		}(), WithInfiniteRetry())
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 100, attempts)
+	})
+
+	t.Run("stop by context", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := Do(ctx, func() error { return testErr },
+			WithTimes(Infinite),
+			WithDelayStrategy(FixedDelay(time.Millisecond)),
+		)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+
+	t.Run("no overflow", func(t *testing.T) {
+		assert.Equal(t, 1, next(0))
+		assert.Equal(t, math.MaxInt-1, next(math.MaxInt-2))
+		assert.Equal(t, math.MaxInt-1, next(math.MaxInt-1))
+	})
+}
+
+func TestName(t *testing.T) {
+	t.Run("wrap final error", func(t *testing.T) {
+		err := Do(context.Background(), func() error { return testErr }, WithTimes(4), WithName("fetch-user"))
+		assert.EqualError(t, err, `retry "fetch-user" gave up after 5 attempts: test`)
+		assert.ErrorIs(t, err, testErr)
+	})
+
+	t.Run("unwrapped without name", func(t *testing.T) {
+		err := Do(context.Background(), func() error { return testErr }, WithTimes(4))
+		assert.Equal(t, testErr, err)
+	})
+
+	t.Run("events and logs", func(t *testing.T) {
+		ch := make(chan RetryEvent, 10)
+		logger := &testLogger{}
+		_ = Do(context.Background(), func() error { return testErr },
+			WithTimes(1),
+			WithName("fetch-user"),
+			WithEventChannel(ch),
+			WithLogger(logger),
+		)
+		close(ch)
+		for event := range ch {
+			assert.Equal(t, "fetch-user", event.Name)
+		}
+		assert.Equal(t, []string{
+			`retry "fetch-user": attempt 0 failed: test, retrying in 0s`,
+			`retry "fetch-user": giving up after 2 attempts: test`,
+		}, logger.lines)
+	})
+}
+
+func TestComposeDelayStrategy(t *testing.T) {
+	t.Run("max", func(t *testing.T) {
+		strategy := MaxDelayStrategy(ExponentialDelay(time.Second, time.Minute), FixedDelay(3*time.Second))
+		assert.Equal(t, 3*time.Second, strategy(0, testErr))
+		assert.Equal(t, 3*time.Second, strategy(1, testErr))
+		assert.Equal(t, 4*time.Second, strategy(2, testErr))
+	})
+
+	t.Run("sum", func(t *testing.T) {
+		strategy := SumDelayStrategy(LinearDelay(time.Second, time.Minute), FixedDelay(time.Second), FixedDelay(time.Millisecond))
+		assert.Equal(t, 2*time.Second+time.Millisecond, strategy(0, testErr))
+		assert.Equal(t, 3*time.Second+time.Millisecond, strategy(1, testErr))
+		assert.Equal(t, time.Duration(0), SumDelayStrategy()(0, testErr))
+	})
+
+	t.Run("sum overflow", func(t *testing.T) {
+		maxDuration := time.Duration(math.MaxInt64)
+		strategy := SumDelayStrategy(FixedDelay(maxDuration), FixedDelay(time.Second))
+		assert.Equal(t, maxDuration, strategy(0, testErr))
+	})
+
+	t.Run("stop delay", func(t *testing.T) {
+		stop := func(n int, err error) time.Duration { return StopDelay }
+		assert.Equal(t, StopDelay, MaxDelayStrategy(FixedDelay(time.Second), stop)(0, testErr))
+		assert.Equal(t, StopDelay, SumDelayStrategy(FixedDelay(time.Second), stop)(0, testErr))
+	})
+}
+
+type testMetrics struct {
+	attempts int
+	retries  int
+	giveUps  int
+	delays   []time.Duration
+}
+
+func (m *testMetrics) IncAttempt() { m.attempts++ }
+
+func (m *testMetrics) IncRetry() { m.retries++ }
+
+func (m *testMetrics) IncGiveUp() { m.giveUps++ }
+
+func (m *testMetrics) ObserveDelay(d time.Duration) { m.delays = append(m.delays, d) }
+
+func TestMetrics(t *testing.T) {
+	t.Run("give up", func(t *testing.T) {
+		m := &testMetrics{}
+		err := Do(context.Background(), func() error { return testErr },
+			WithTimes(2),
+			WithDelayStrategy(LinearDelay(time.Millisecond, time.Second)),
+			WithMetrics(m),
+		)
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, &testMetrics{
+			attempts: 3,
+			retries:  2,
+			giveUps:  1,
+			delays:   []time.Duration{time.Millisecond, 2 * time.Millisecond},
+		}, m)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		m := &testMetrics{}
+		err := Do(context.Background(), SuccessOnMaxCallFunc(2), WithTimes(2), WithMetrics(m))
+		assert.Nil(t, err)
+		assert.Equal(t, &testMetrics{attempts: 2, retries: 1, delays: []time.Duration{0}}, m)
+	})
+}
+
+type spanKey struct{}
+
+type testSpan struct {
+	name   string
+	parent string
+	err    error
+	ended  bool
+}
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) StartSpan(ctx context.Context, name string) (context.Context, func(err error)) {
+	span := &testSpan{name: name}
+	if parent, ok := ctx.Value(spanKey{}).(*testSpan); ok {
+		span.parent = parent.name
+	}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), func(err error) {
+		span.err, span.ended = err, true
+	}
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &testTracer{}
+	var attemptSpans []string
+	err := DoCtx(context.Background(), func(ctx context.Context) error {
+		attemptSpans = append(attemptSpans, ctx.Value(spanKey{}).(*testSpan).name)
+		if len(attemptSpans) < 2 {
+			return testErr
+		}
+		return nil
+	}, WithTimes(2), WithTracer(tracer))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"retry.attempt", "retry.attempt"}, attemptSpans)
+	assert.Equal(t, []*testSpan{
+		{name: "retry.Do", ended: true},
+		{name: "retry.attempt", parent: "retry.Do", err: testErr, ended: true},
+		{name: "retry.attempt", parent: "retry.Do", ended: true},
+	}, tracer.spans)
+
+	t.Run("give up", func(t *testing.T) {
+		tracer := &testTracer{}
+		err := Do(context.Background(), func() error { return testErr }, WithTimes(1), WithTracer(tracer))
+		assert.Equal(t, testErr, err)
+		assert.Len(t, tracer.spans, 3)
+		for _, span := range tracer.spans {
+			assert.True(t, span.ended)
+			assert.Equal(t, testErr, span.err)
+		}
+	})
+
+	t.Run("recovered panic", func(t *testing.T) {
+		tracer := &testTracer{}
+		_ = Do(context.Background(), func() error { panic("boom") },
+			WithTracer(tracer),
+			WithRecover(func(r any) error { return testErr }),
+		)
+		assert.Equal(t, testErr, tracer.spans[1].err)
+	})
+}
+
+func TestDoResult(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		result := DoResult(context.Background(), SuccessOnMaxCallFunc(3),
+			WithTimes(5),
+			WithDelayStrategy(FixedDelay(10*time.Millisecond)),
+		)
+		assert.Equal(t, Result{Attempts: 3, TotalDelay: 20 * time.Millisecond, LastErr: testErr}, result)
+	})
+
+	t.Run("all failed", func(t *testing.T) {
+		result := DoResult(context.Background(), func() error { return testErr },
+			WithTimes(2),
+			WithInitialDelay(5*time.Millisecond),
+			WithDelayStrategy(FixedDelay(10*time.Millisecond)),
+		)
+		assert.Equal(t, Result{Attempts: 3, TotalDelay: 25 * time.Millisecond, Err: testErr, LastErr: testErr}, result)
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		opts := []Option{WithTimes(2), WithDelayStrategy(FixedDelay(time.Second))}
+		result := DoResult(ctx, func() error { return testErr }, opts...)
+		assert.Equal(t, Result{Attempts: 1, Err: context.DeadlineExceeded, LastErr: testErr}, result)
+	})
+
+	t.Run("err matches Do", func(t *testing.T) {
+		opts := []Option{WithTimes(2), WithCombineErrors(), WithName("test")}
+		result := DoResult(context.Background(), func() error { return testErr }, opts...)
+		assert.Equal(t, Do(context.Background(), func() error { return testErr }, opts...), result.Err)
+		assert.Equal(t, testErr, result.LastErr)
+	})
+}
+
+func TestOnDelayInterrupted(t *testing.T) {
+	t.Run("interrupted", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		var remainingList []time.Duration
+		err := Do(ctx, func() error { return testErr },
+			WithTimes(2),
+			WithDelayStrategy(FixedDelay(200*time.Millisecond)),
+			WithOnDelayInterrupted(func(remaining time.Duration) { remainingList = append(remainingList, remaining) }),
+		)
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.Len(t, remainingList, 1)
+		assert.Greater(t, remainingList[0], 150*time.Millisecond-30*time.Millisecond)
+		assert.Less(t, remainingList[0], 150*time.Millisecond+10*time.Millisecond)
+	})
+
+	t.Run("not interrupted", func(t *testing.T) {
+		called := false
+		err := Do(context.Background(), func() error { return testErr },
+			WithTimes(2),
+			WithOnDelayInterrupted(func(remaining time.Duration) { called = true }),
+		)
+		assert.Equal(t, testErr, err)
+		assert.False(t, called)
+	})
+}
+
+func BenchmarkDo(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = Do(context.Background(), func() error { return testErr },
+			WithTimes(10),
+			WithDelayStrategy(FixedDelay(time.Microsecond)),
+		)
+	}
+}
+
+func BenchmarkDoNoRetry(b *testing.B) {
+	config := NewConfig()
+	fn := func() error { return testErr }
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = config.Do(context.Background(), fn)
+	}
+}
+
+func TestDoNoRetry(t *testing.T) {
+	t.Run("same as full path", func(t *testing.T) {
+		fns := []func() error{
+			func() error { return nil },
+			func() error { return testErr },
+			func() error { return Break(testErr) },
+			func() error { return Break(nil) },
+			func() error { return Unrecoverable(testErr) },
+		}
+		for _, fn := range fns {
+			config := NewConfig()
+			assert.True(t, config.once())
+			fast := config.DoResult(context.Background(), fn)
+			full := config.do(context.Background(), func(context.Context) error { return fn() })
+			assert.Equal(t, full, fast)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		exec := 0
+		attempts, err := DoN(ctx, func() error { exec++; return nil })
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, 0, attempts)
+		assert.Equal(t, 0, exec)
+	})
+
+	t.Run("full path when configured", func(t *testing.T) {
+		assert.False(t, NewConfig(WithTimes(1)).once())
+		assert.False(t, NewConfig(WithName("x")).once())
+		assert.False(t, NewConfig(WithOnFailedFunc(func(n int, err error) {})).once())
+		assert.False(t, NewConfig(WithCombineErrors()).once())
+	})
+
+	t.Run("no allocations", func(t *testing.T) {
+		config := NewConfig()
+		fn := func() error { return testErr }
+		ctx := context.Background()
+		allocs := testing.AllocsPerRun(100, func() {
+			_ = config.Do(ctx, fn)
+		})
+		assert.Equal(t, float64(0), allocs)
+	})
+}
+
+func TestClock(t *testing.T) {
+	t.Run("fake clock", func(t *testing.T) {
+		clock := retrytest.NewFakeClock(time.Now())
+		var elapsedList []time.Duration
+		done := make(chan Result)
+		go func() {
+			done <- DoResult(context.Background(), SuccessOnMaxCallFunc(4),
+				WithTimes(5),
+				WithDelayStrategyV2(func(n int, elapsed time.Duration, err error) time.Duration {
+					elapsedList = append(elapsedList, elapsed)
+					return time.Hour
+				}),
+				WithClock(clock),
+			)
+		}()
+		for i := 1; i <= 3; i++ {
+			clock.BlockUntil(1)
+			clock.Advance(time.Hour)
+		}
+		s := time.Now()
+		result := <-done
+		assert.Less(t, time.Since(s), time.Second)
+		assert.Nil(t, result.Err)
+		assert.Equal(t, 4, result.Attempts)
+		assert.Equal(t, 3*time.Hour, result.TotalDelay)
+		assert.Equal(t, []time.Duration{0, time.Hour, 2 * time.Hour}, elapsedList)
+	})
+
+	t.Run("max elapsed time", func(t *testing.T) {
+		clock := retrytest.NewFakeClock(time.Now())
+		done := make(chan Result)
+		go func() {
+			done <- DoResult(context.Background(), func() error { return testErr },
+				WithTimes(10),
+				WithDelayStrategy(FixedDelay(time.Hour)),
+				WithMaxElapsedTime(150*time.Minute),
+				WithClock(clock),
+			)
+		}()
+		for i := 1; i <= 2; i++ {
+			clock.BlockUntil(1)
+			clock.Advance(time.Hour)
+		}
+		result := <-done
+		assert.Equal(t, testErr, result.Err)
+		assert.Equal(t, 3, result.Attempts)
+	})
+}
+
+func TestSeverityScaledDelay(t *testing.T) {
+	severeErr := errors.New("severe")
+	strategy := SeverityScaledDelay(FixedDelay(time.Second), func(err error) float64 {
+		switch {
+		case errors.Is(err, severeErr):
+			return 3.0
+		case err == nil:
+			return math.NaN()
+		case errors.Is(err, context.Canceled):
+			return -1
+		case errors.Is(err, context.DeadlineExceeded):
+			return math.Inf(1)
+		}
+		return 1.0
+	})
+	assert.Equal(t, time.Second, strategy(0, testErr))
+	assert.Equal(t, 3*time.Second, strategy(0, severeErr))
+	assert.Equal(t, time.Duration(0), strategy(0, nil))
+	assert.Equal(t, time.Duration(0), strategy(0, context.Canceled))
+	assert.Equal(t, time.Duration(math.MaxInt64), strategy(0, context.DeadlineExceeded))
+
+	stop := SeverityScaledDelay(func(n int, err error) time.Duration { return StopDelay }, func(err error) float64 { return 2 })
+	assert.Equal(t, StopDelay, stop(0, testErr))
+}
+
+func TestWeightedDelay(t *testing.T) {
+	t.Run("distribution", func(t *testing.T) {
+		strategy := WeightedDelayWithSource(rand.New(rand.NewSource(1)),
+			WeightedStrategy{Strategy: FixedDelay(1), Weight: 1},
+			WeightedStrategy{Strategy: FixedDelay(2), Weight: 0},
+			WeightedStrategy{Strategy: FixedDelay(3), Weight: 3},
+			WeightedStrategy{Strategy: FixedDelay(4), Weight: -1},
+		)
+		counts := map[time.Duration]int{}
+		const total = 10000
+		for i := 0; i < total; i++ {
+			counts[strategy(i, testErr)]++
+		}
+		assert.Len(t, counts, 2)
+		assert.InDelta(t, 0.25, float64(counts[1])/total, 0.02)
+		assert.InDelta(t, 0.75, float64(counts[3])/total, 0.02)
+	})
+
+	t.Run("passes n and err", func(t *testing.T) {
+		strategy := WeightedDelay(WeightedStrategy{Strategy: func(n int, err error) time.Duration {
+			assert.Equal(t, testErr, err)
+			return time.Duration(n)
+		}, Weight: 1})
+		assert.Equal(t, time.Duration(5), strategy(5, testErr))
+	})
+
+	t.Run("no entries", func(t *testing.T) {
+		assert.Equal(t, time.Duration(0), WeightedDelay()(0, testErr))
+		assert.Equal(t, time.Duration(0), WeightedDelay(WeightedStrategy{Strategy: FixedDelay(1), Weight: 0})(0, testErr))
+	})
+}
+
+type retryAtError struct {
+	at time.Time
+}
+
+func (e retryAtError) Error() string {
+	return "retry at " + e.at.String()
+}
+
+func TestUntilDelay(t *testing.T) {
+	clock := retrytest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	target := func(err error) time.Time {
+		var retryAtErr retryAtError
+		if errors.As(err, &retryAtErr) {
+			return retryAtErr.at
+		}
+		return time.Time{}
+	}
+	strategy := UntilDelayWithClock(clock, target)
+	assert.Equal(t, 5*time.Second, strategy(0, retryAtError{at: clock.Now().Add(5 * time.Second)}))
+	assert.Equal(t, time.Duration(0), strategy(0, retryAtError{at: clock.Now().Add(-5 * time.Second)}))
+	assert.Equal(t, time.Duration(0), strategy(0, testErr))
+
+	delay := UntilDelay(target)(0, retryAtError{at: time.Now().Add(time.Hour)})
+	assert.True(t, delay > 59*time.Minute && delay <= time.Hour)
+}
+
+func TestCappedByDeadline(t *testing.T) {
+	t.Run("final attempt still executes", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		exec := 0
+		err := Do(ctx, func() error {
+			exec++
+			if exec >= 2 {
+				return nil
+			}
+			return testErr
+		},
+			WithTimes(5),
+			WithDelayStrategy(CappedByDeadline(ctx, FixedDelay(time.Second), 20*time.Millisecond)),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, exec)
+	})
+
+	t.Run("stop when no time left", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		strategy := CappedByDeadline(ctx, FixedDelay(time.Second), 20*time.Millisecond)
+		assert.Equal(t, StopDelay, strategy(0, testErr))
+	})
+
+	t.Run("no deadline", func(t *testing.T) {
+		strategy := CappedByDeadline(context.Background(), FixedDelay(time.Second), 20*time.Millisecond)
+		assert.Equal(t, time.Second, strategy(0, testErr))
+	})
+}
+
+func TestErrorDelayOverride(t *testing.T) {
+	rateLimitErr := errors.New("rate limit")
+	resetErr := errors.New("connection reset")
+	config := NewConfig(
+		WithTimes(1),
+		WithDelayStrategy(FixedDelay(time.Millisecond)),
+		WithErrorDelayOverride(func(err error) bool { return errors.Is(err, rateLimitErr) }, FixedDelay(30*time.Millisecond)),
+		WithErrorDelayOverride(func(err error) bool { return errors.Is(err, resetErr) }, FixedDelay(20*time.Millisecond)),
+		WithErrorDelayOverride(func(err error) bool { return true }, FixedDelay(time.Hour)),
+	)
+	tests := []struct {
+		err   error
+		delay time.Duration
+	}{
+		{err: rateLimitErr, delay: 30 * time.Millisecond},
+		{err: fmt.Errorf("wrapped: %w", resetErr), delay: 20 * time.Millisecond},
+		{err: testErr, delay: time.Hour},
+	}
+	for _, test := range tests {
+		t.Run(test.err.Error(), func(t *testing.T) {
+			strategy := overrideDelayStrategy(adaptDelayStrategy(config.DelayStrategy), config.DelayOverrides)
+			assert.Equal(t, test.delay, strategy(0, 0, test.err))
+		})
+	}
+
+	t.Run("fallback", func(t *testing.T) {
+		strategy := overrideDelayStrategy(adaptDelayStrategy(config.DelayStrategy), config.DelayOverrides[:2])
+		assert.Equal(t, time.Millisecond, strategy(0, 0, testErr))
+	})
+
+	t.Run("do", func(t *testing.T) {
+		exec := 0
+		result := DoResult(context.Background(), func() error {
+			exec++
+			if exec == 1 {
+				return rateLimitErr
+			}
+			return resetErr
+		},
+			WithTimes(2),
+			WithDelayStrategy(FixedDelay(time.Millisecond)),
+			WithErrorDelayOverride(func(err error) bool { return errors.Is(err, rateLimitErr) }, FixedDelay(3*time.Millisecond)),
+			WithErrorDelayOverride(func(err error) bool { return errors.Is(err, resetErr) }, FixedDelay(2*time.Millisecond)),
+		)
+		assert.Equal(t, resetErr, result.Err)
+		assert.Equal(t, 5*time.Millisecond, result.TotalDelay)
+	})
+}
+
+func TestRandSource(t *testing.T) {
+	newStrategy := func() *RandStrategy {
+		return NewRandStrategy(func(r *rand.Rand) DelayStrategy {
+			return RandomDelayWithSource(0, time.Second, r)
+		})
+	}
+	delays := func(config *Config) []time.Duration {
+		var delays []time.Duration
+		for n := 0; n < 5; n++ {
+			delays = append(delays, config.DelayStrategy(n, testErr))
+		}
+		return delays
+	}
+
+	t.Run("reproducible", func(t *testing.T) {
+		config1 := NewConfig(WithRandSource(rand.New(rand.NewSource(1))), WithRandAwareDelayStrategy(newStrategy()))
+		config2 := NewConfig(WithRandAwareDelayStrategy(newStrategy()), WithRandSource(rand.New(rand.NewSource(1))))
+		assert.Equal(t, delays(config1), delays(config2))
+	})
+
+	t.Run("global source by default", func(t *testing.T) {
+		config := NewConfig(WithRandAwareDelayStrategy(newStrategy()))
+		for _, delay := range delays(config) {
+			assert.True(t, delay >= 0 && delay <= time.Second)
+		}
+	})
+
+	t.Run("do", func(t *testing.T) {
+		err := Do(context.Background(), SuccessOnMaxCallFunc(2),
+			WithTimes(2),
+			WithRandAwareDelayStrategy(NewRandStrategy(func(r *rand.Rand) DelayStrategy {
+				return RandomDelayWithSource(time.Millisecond, 2*time.Millisecond, r)
+			})),
+			WithRandSource(rand.New(rand.NewSource(1))),
+		)
+		assert.Nil(t, err)
+	})
+}
+
+func TestNilFunc(t *testing.T) {
+	assert.Equal(t, ErrNilFunc, Do(context.Background(), nil))
+	assert.Equal(t, ErrNilFunc, DoCtx(context.Background(), nil))
+	assert.Equal(t, Result{Err: ErrNilFunc}, DoResult(context.Background(), nil))
+	data, err := DoWithData[int](context.Background(), nil)
+	assert.Equal(t, 0, data)
+	assert.Equal(t, ErrNilFunc, err)
+	assert.Equal(t, []error{nil, ErrNilFunc}, DoAll(context.Background(), []func() error{func() error { return nil }, nil}))
+}
+
+func TestNilContext(t *testing.T) {
+	attempts, err := DoN(nil, SuccessOnMaxCallFunc(2), WithTimes(2))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, []error{nil}, DoAll(nil, []func() error{func() error { return nil }}, WithConcurrency(2)))
+	assert.Nil(t, DoFirst(nil, []func() error{func() error { return nil }}))
+}
+
+func TestSuccessOn(t *testing.T) {
+	isEOF := func(err error) bool { return errors.Is(err, io.EOF) }
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		err      error
+	}{
+		{name: "success on match", errs: []error{testErr, fmt.Errorf("read: %w", io.EOF)}, attempts: 2},
+		{name: "no match", errs: []error{testErr, testErr, testErr}, attempts: 3, err: testErr},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var onSuccess, retryIf int
+			exec := 0
+			attempts, err := DoN(context.Background(), func() error {
+				exec++
+				return test.errs[exec-1]
+			},
+				WithTimes(2),
+				WithSuccessOn(func(err error) bool { return false }),
+				WithSuccessOn(isEOF),
+				WithOnSuccessFunc(func(n int) { onSuccess++ }),
+				WithRetryIf(func(err error) bool { retryIf++; return true }),
+			)
+			assert.Equal(t, test.err, err)
+			assert.Equal(t, test.attempts, attempts)
+			if test.err == nil {
+				assert.Equal(t, 1, onSuccess)
+				assert.Equal(t, 1, retryIf)
+			}
+		})
+	}
+}
+
+func TestErrorTransform(t *testing.T) {
+	driverErr := errors.New("driver: code 40001")
+	errNotFound := errors.New("not found")
+	errFatal := errors.New("fatal")
+	transform := func(err error) error {
+		switch {
+		case errors.Is(err, driverErr):
+			return testErr
+		case errors.Is(err, errNotFound):
+			return nil
+		case errors.Is(err, errFatal):
+			return Break(err)
+		}
+		return err
+	}
+
+	t.Run("transformed error flows through", func(t *testing.T) {
+		var failed, retryIf []error
+		attempts, err := DoN(context.Background(), func() error { return driverErr },
+			WithTimes(2),
+			WithErrorTransform(transform),
+			WithOnFailedFunc(func(n int, err error) { failed = append(failed, err) }),
+			WithRetryIf(func(err error) bool { retryIf = append(retryIf, err); return true }),
+			WithDelayStrategy(func(n int, err error) time.Duration {
+				assert.Equal(t, testErr, err)
+				return 0
+			}),
+		)
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 3, attempts)
+		assert.Equal(t, []error{testErr, testErr, testErr}, failed)
+		assert.Equal(t, []error{testErr, testErr}, retryIf)
+	})
+
+	t.Run("nil means success", func(t *testing.T) {
+		attempts, err := DoN(context.Background(), func() error { return errNotFound },
+			WithTimes(2),
+			WithErrorTransform(transform),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("transform to break", func(t *testing.T) {
+		attempts, err := DoN(context.Background(), func() error { return errFatal },
+			WithTimes(2),
+			WithErrorTransform(transform),
+		)
+		assert.Equal(t, errFatal, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("break passed to transform", func(t *testing.T) {
+		var transformed error
+		attempts, err := DoN(context.Background(), func() error { return Break(testErr) },
+			WithTimes(2),
+			WithErrorTransform(func(err error) error {
+				transformed = err
+				return err
+			}),
+		)
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 1, attempts)
+		assert.Equal(t, Break(testErr), transformed)
+	})
+}
+
+func TestTrailingDelay(t *testing.T) {
+	t.Run("no trailing delay by default", func(t *testing.T) {
+		var delays []int
+		result := DoResult(context.Background(), func() error { return testErr },
+			WithTimes(2),
+			WithDelayStrategy(func(n int, err error) time.Duration {
+				delays = append(delays, n)
+				return 50 * time.Millisecond
+			}),
+		)
+		assert.Equal(t, testErr, result.Err)
+		assert.Equal(t, 3, result.Attempts)
+		assert.Equal(t, []int{0, 1}, delays)
+		assert.Equal(t, 100*time.Millisecond, result.TotalDelay)
+	})
+
+	t.Run("trailing delay", func(t *testing.T) {
+		var delays []int
+		start := time.Now()
+		result := DoResult(context.Background(), func() error { return testErr },
+			WithTimes(2),
+			WithDelayStrategy(func(n int, err error) time.Duration {
+				delays = append(delays, n)
+				return 50 * time.Millisecond
+			}),
+			WithTrailingDelay(),
+		)
+		assert.Equal(t, testErr, result.Err)
+		assert.Equal(t, 3, result.Attempts)
+		assert.Equal(t, []int{0, 1, 2}, delays)
+		assert.Equal(t, 150*time.Millisecond, result.TotalDelay)
+		assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+	})
+
+	t.Run("no trailing delay on break", func(t *testing.T) {
+		result := DoResult(context.Background(), func() error { return Break(testErr) },
+			WithDelayStrategy(FixedDelay(time.Hour)),
+			WithTrailingDelay(),
+		)
+		assert.Equal(t, testErr, result.Err)
+		assert.Equal(t, time.Duration(0), result.TotalDelay)
+	})
+
+	t.Run("context canceled during trailing delay", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		result := DoResult(ctx, func() error { return testErr },
+			WithDelayStrategy(FixedDelay(time.Hour)),
+			WithTrailingDelay(),
+		)
+		assert.Equal(t, context.DeadlineExceeded, result.Err)
+		assert.Equal(t, 1, result.Attempts)
+	})
+}
+
+func TestExhaustedError(t *testing.T) {
+	permanentErr := errors.New("permanent failure")
+	exhaustedError := func(lastErr error, attempts int) error {
+		return fmt.Errorf("%w after %d attempts: %w", permanentErr, attempts, lastErr)
+	}
+
+	t.Run("exhausted", func(t *testing.T) {
+		err := Do(context.Background(), func() error { return testErr },
+			WithTimes(2),
+			WithExhaustedError(exhaustedError),
+		)
+		assert.ErrorIs(t, err, permanentErr)
+		assert.ErrorIs(t, err, testErr)
+		assert.Equal(t, "permanent failure after 3 attempts: test", err.Error())
+	})
+
+	tests := []struct {
+		name string
+		fn   func() error
+		opts []Option
+		err  error
+	}{
+		{name: "break", fn: func() error { return Break(testErr) }, err: testErr},
+		{name: "unrecoverable", fn: func() error { return Unrecoverable(testErr) }, err: Unrecoverable(testErr)},
+		{name: "retry if", fn: func() error { return testErr }, opts: []Option{WithRetryIf(func(err error) bool { return false })}, err: testErr},
+		{name: "success", fn: func() error { return nil }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Do(context.Background(), test.fn, append(test.opts, WithTimes(2), WithExhaustedError(exhaustedError))...)
+			assert.Equal(t, test.err, err)
+		})
+	}
+
+	t.Run("context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		err := Do(ctx, func() error { cancel(); return testErr },
+			WithTimes(2),
+			WithExhaustedError(exhaustedError),
+		)
+		assert.Equal(t, context.Canceled, err)
+	})
+}
+
+func TestHealthCheck(t *testing.T) {
+	t.Run("wait until probe passes", func(t *testing.T) {
+		var calls []string
+		probes := 0
+		result := DoResult(context.Background(), func() error {
+			calls = append(calls, "fn")
+			return testErr
+		},
+			WithTimes(2),
+			WithDelayStrategy(FixedDelay(time.Millisecond)),
+			WithHealthCheck(func(ctx context.Context) error {
+				calls = append(calls, "probe")
+				probes++
+				if probes < 3 {
+					return errors.New("unhealthy")
+				}
+				return nil
+			}),
+		)
+		assert.Equal(t, testErr, result.Err)
+		assert.Equal(t, 3, result.Attempts)
+		assert.Equal(t, []string{"fn", "probe", "probe", "probe", "fn", "probe", "fn"}, calls)
+		// 重试间隔1ms, 探测失败后从10ms开始翻倍
+		assert.Equal(t, time.Millisecond+10*time.Millisecond+20*time.Millisecond+time.Millisecond, result.TotalDelay)
+	})
+
+	t.Run("zero delay does not spin", func(t *testing.T) {
+		probes := 0
+		start := time.Now()
+		result := DoResult(context.Background(), func() error { return testErr },
+			WithTimes(Infinite),
+			WithMaxElapsedTime(50*time.Millisecond),
+			WithHealthCheck(func(ctx context.Context) error {
+				probes++
+				return errors.New("unhealthy")
+			}),
+		)
+		assert.Equal(t, testErr, result.Err)
+		assert.Equal(t, 1, result.Attempts)
+		// 10ms + 20ms后再等待40ms会超出50ms
+		assert.Equal(t, 3, probes)
+		assert.Equal(t, 30*time.Millisecond, result.TotalDelay)
+		assert.Less(t, time.Since(start), 50*time.Millisecond+40*time.Millisecond)
+	})
+
+	t.Run("failed probes limited by retry times", func(t *testing.T) {
+		probes := 0
+		attempts, err := DoN(context.Background(), func() error { return testErr },
+			WithTimes(2),
+			WithHealthCheck(func(ctx context.Context) error {
+				probes++
+				return errors.New("unhealthy")
+			}),
+		)
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 1, attempts)
+		assert.Equal(t, 3, probes)
+	})
+
+	t.Run("stopped while probing", func(t *testing.T) {
+		stop := make(chan struct{})
+		err := Do(context.Background(), func() error { return testErr },
+			WithTimes(Infinite),
+			WithStopChannel(stop),
+			WithHealthCheck(func(ctx context.Context) error {
+				close(stop)
+				return errors.New("unhealthy")
+			}),
+		)
+		assert.Equal(t, ErrStopped, err)
+	})
+
+	t.Run("not probed on success", func(t *testing.T) {
+		probes := 0
+		err := Do(context.Background(), func() error { return nil },
+			WithHealthCheck(func(ctx context.Context) error { probes++; return nil }),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, probes)
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		attempts, err := DoN(ctx, func() error { return testErr },
+			WithTimes(5),
+			WithDelayStrategy(FixedDelay(time.Millisecond)),
+			WithHealthCheck(func(ctx context.Context) error { return errors.New("unhealthy") }),
+		)
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.Equal(t, 1, attempts)
+	})
+}
+
+func TestEstimateAttempts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	stopAfter := func(n int, err error) time.Duration {
+		assert.Nil(t, err)
+		if n >= 2 {
+			return StopDelay
+		}
+		return time.Second
+	}
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		strategy DelayStrategy
+		maxTimes int
+		want     int
+	}{
+		{name: "deadline", ctx: ctx, strategy: FixedDelay(25 * time.Second), maxTimes: 10, want: 3},
+		{name: "max times", ctx: ctx, strategy: FixedDelay(time.Second), maxTimes: 5, want: 6},
+		{name: "exponential", ctx: ctx, strategy: ExponentialDelay(time.Second, time.Hour), maxTimes: Infinite, want: 6},
+		{name: "stop delay", ctx: ctx, strategy: stopAfter, maxTimes: 10, want: 3},
+		{name: "no deadline", ctx: context.Background(), strategy: FixedDelay(time.Hour), maxTimes: 3, want: 4},
+		{name: "no deadline infinite", ctx: context.Background(), strategy: FixedDelay(time.Hour), maxTimes: Infinite, want: math.MaxInt},
+		{name: "zero delay infinite", ctx: ctx, strategy: FixedDelay(0), maxTimes: Infinite, want: math.MaxInt},
+		{name: "canceled", ctx: canceled, strategy: FixedDelay(0), maxTimes: 3, want: 0},
+		{name: "no retry", ctx: ctx, strategy: FixedDelay(time.Hour), maxTimes: 0, want: 1},
+		{name: "nil ctx", ctx: nil, strategy: FixedDelay(time.Hour), maxTimes: 3, want: 4},
+		{name: "zero delay beyond iteration limit", ctx: ctx, strategy: FixedDelay(0), maxTimes: 20000, want: 20001},
+		{name: "max times beyond iteration limit", ctx: ctx, strategy: FixedDelay(time.Microsecond), maxTimes: 20000, want: 20001},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, EstimateAttempts(test.ctx, test.strategy, test.maxTimes))
+		})
+	}
+
+	t.Run("small delay infinite", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+		defer cancel()
+		start := time.Now()
+		attempts := EstimateAttempts(ctx, FixedDelay(10*time.Microsecond), Infinite)
+		assert.Less(t, time.Since(start), 100*time.Millisecond)
+		assert.InDelta(t, 360_000_000, attempts, 100_000)
+	})
+}
+
+func TestStopChannel(t *testing.T) {
+	t.Run("not wrapped as context error", func(t *testing.T) {
+		called := false
+		err := Do(context.Background(), func() error { return testErr },
+			WithTimes(3),
+			WithStopChannel(closedChan()),
+			WithContextErrorWrapping(),
+			WithOnContextCancelFunc(func(n int, lastErr error) { called = true }),
+		)
+		assert.Equal(t, ErrStopped, err)
+		assert.False(t, called)
+	})
+
+	t.Run("stop during delay", func(t *testing.T) {
+		stop := make(chan struct{})
+		time.AfterFunc(20*time.Millisecond, func() { close(stop) })
+		var remaining time.Duration
+		start := time.Now()
+		attempts, err := DoN(context.Background(), func() error { return testErr },
+			WithTimes(3),
+			WithDelayStrategy(FixedDelay(time.Hour)),
+			WithStopChannel(stop),
+			WithOnDelayInterrupted(func(r time.Duration) { remaining = r }),
+		)
+		assert.Equal(t, ErrStopped, err)
+		assert.Equal(t, 1, attempts)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Greater(t, remaining, 59*time.Minute)
+	})
+
+	t.Run("stop during attempt", func(t *testing.T) {
+		stop := make(chan struct{})
+		ctxErr := error(nil)
+		err := DoCtx(context.Background(), func(ctx context.Context) error {
+			close(stop)
+			ctxErr = ctx.Err()
+			return testErr
+		},
+			WithTimes(3),
+			WithStopChannel(stop),
+			WithPreserveLastError(),
+		)
+		assert.ErrorIs(t, err, ErrStopped)
+		assert.ErrorIs(t, err, testErr)
+		assert.Nil(t, ctxErr)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		stop := make(chan struct{})
+		close(stop)
+		assert.Nil(t, Do(context.Background(), func() error { return nil }, WithStopChannel(stop)))
+	})
+}
+
+type testLimiter struct {
+	waits int
+	err   error
+}
+
+func (l *testLimiter) Wait(ctx context.Context) error {
+	l.waits++
+	if l.err != nil && l.waits > 2 {
+		return l.err
+	}
+	return ctx.Err()
+}
+
+type cancelLimiter struct {
+	waits  int
+	cancel context.CancelFunc
+}
+
+func (l *cancelLimiter) Wait(ctx context.Context) error {
+	l.waits++
+	if l.waits > 1 {
+		l.cancel()
+	}
+	return ctx.Err()
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("wait before each attempt", func(t *testing.T) {
+		limiter := &testLimiter{}
+		attempts, err := DoN(context.Background(), func() error { return testErr },
+			WithTimes(3),
+			WithRateLimiter(limiter),
+		)
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 4, attempts)
+		assert.Equal(t, 4, limiter.waits)
+	})
+
+	t.Run("wait error", func(t *testing.T) {
+		limiterErr := errors.New("rate: Wait(n=1) would exceed context deadline")
+		limiter := &testLimiter{err: limiterErr}
+		attempts, err := DoN(context.Background(), func() error { return testErr },
+			WithTimes(3),
+			WithRateLimiter(limiter),
+		)
+		assert.Equal(t, limiterErr, err)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		attempts, err := DoN(ctx, func() error { cancel(); return testErr },
+			WithTimes(3),
+			WithRateLimiter(&testLimiter{}),
+		)
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, 1, attempts)
+	})
+}
+
+func TestTypedErrors(t *testing.T) {
+	t.Run("exhausted", func(t *testing.T) {
+		err := Do(context.Background(), func() error { return testErr }, WithTimes(2), WithTypedErrors())
+		assert.ErrorIs(t, err, ErrRetriesExhausted)
+		assert.ErrorIs(t, err, testErr)
+		assert.Equal(t, testErr, errors.Unwrap(err))
+		var exhaustedErr *RetriesExhaustedError
+		assert.ErrorAs(t, err, &exhaustedErr)
+		assert.Equal(t, 3, exhaustedErr.Attempts)
+		assert.Equal(t, "retry: retries exhausted after 3 attempts: test", err.Error())
+	})
+
+	t.Run("not exhausted", func(t *testing.T) {
+		err := Do(context.Background(), func() error { return Break(testErr) }, WithTimes(2), WithTypedErrors())
+		assert.Equal(t, testErr, err)
+		err = Do(context.Background(), func() error { return testErr },
+			WithTimes(2),
+			WithTypedErrors(),
+			WithRetryIf(func(err error) bool { return false }),
+		)
+		assert.Equal(t, testErr, err)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		err := Do(context.Background(), func() error { return testErr }, WithTimes(2))
+		assert.False(t, errors.Is(err, ErrRetriesExhausted))
+	})
+}
+
+func TestContextCheckBeforeAttempt(t *testing.T) {
+	t.Run("canceled during delay", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		exec := 0
+		attempts, err := DoN(ctx, func() error { exec++; return testErr },
+			WithTimes(5),
+			WithDelayStrategy(FixedDelay(time.Hour)),
+		)
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, 1, attempts)
+		assert.Equal(t, 1, exec)
+	})
+
+	t.Run("canceled during health check", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		exec := 0
+		attempts, err := DoN(ctx, func() error { exec++; return testErr },
+			WithTimes(5),
+			WithHealthCheck(func(ctx context.Context) error {
+				cancel()
+				return nil
+			}),
+			WithPreserveLastError(),
+		)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.ErrorIs(t, err, testErr)
+		assert.Equal(t, 1, attempts)
+		assert.Equal(t, 1, exec)
+	})
+}
+
+func TestFirstRetryDelay(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		total time.Duration
+	}{
+		{name: "only first delay overridden", opts: []Option{WithFirstRetryDelay(time.Millisecond)}, total: time.Millisecond + 20*time.Millisecond + 40*time.Millisecond},
+		{name: "immediate first retry", opts: []Option{WithFirstRetryDelay(0)}, total: 20*time.Millisecond + 40*time.Millisecond},
+		{name: "min delay", opts: []Option{WithFirstRetryDelay(0), WithMinDelay(5 * time.Millisecond)}, total: 5*time.Millisecond + 20*time.Millisecond + 40*time.Millisecond},
+		{name: "max delay", opts: []Option{WithFirstRetryDelay(time.Hour), WithMaxDelay(30 * time.Millisecond)}, total: 30*time.Millisecond + 20*time.Millisecond + 30*time.Millisecond},
+		{name: "default", total: 10*time.Millisecond + 20*time.Millisecond + 40*time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := DoResult(context.Background(), func() error { return testErr },
+				append([]Option{WithTimes(3), WithDelayStrategy(ExponentialDelay(10*time.Millisecond, time.Hour))}, test.opts...)...,
+			)
+			assert.Equal(t, test.total, result.TotalDelay)
+		})
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	t.Run("success after retry", func(t *testing.T) {
+		var numbers []int
+		for attempt := range Attempts(context.Background(), WithTimes(5)) {
+			numbers = append(numbers, attempt.Number())
+			if attempt.Number() < 2 {
+				attempt.Fail(testErr)
+				continue
+			}
+			attempt.Success()
+		}
+		assert.Equal(t, []int{0, 1, 2}, numbers)
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		var giveUpErr error
+		count := 0
+		for attempt := range Attempts(context.Background(), WithTimes(2), WithOnGiveUpFunc(func(attempts int, err error) { giveUpErr = err })) {
+			count++
+			attempt.Fail(testErr)
+		}
+		assert.Equal(t, 3, count)
+		assert.Equal(t, testErr, giveUpErr)
+	})
+
+	t.Run("no feedback means success", func(t *testing.T) {
+		count := 0
+		for range Attempts(context.Background(), WithTimes(2)) {
+			count++
+		}
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("break", func(t *testing.T) {
+		count := 0
+		for attempt := range Attempts(context.Background(), WithTimes(5)) {
+			count++
+			attempt.Fail(testErr)
+			break
+		}
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("retry if and delay", func(t *testing.T) {
+		fatalErr := errors.New("fatal")
+		var delays []int
+		count := 0
+		for attempt := range Attempts(context.Background(),
+			WithTimes(5),
+			WithRetryIf(func(err error) bool { return !errors.Is(err, fatalErr) }),
+			WithDelayStrategy(func(n int, err error) time.Duration { delays = append(delays, n); return 0 }),
+		) {
+			count++
+			if attempt.Number() == 2 {
+				attempt.Fail(fatalErr)
+			} else {
+				attempt.Fail(testErr)
+			}
+		}
+		assert.Equal(t, 3, count)
+		assert.Equal(t, []int{0, 1}, delays)
+	})
+
+	t.Run("loop body panic not recovered", func(t *testing.T) {
+		count := 0
+		recovered := false
+		assert.PanicsWithValue(t, "boom", func() {
+			for range Attempts(context.Background(), WithTimes(3), WithRecover(func(r any) error {
+				recovered = true
+				return testErr
+			})) {
+				count++
+				panic("boom")
+			}
+		})
+		assert.Equal(t, 1, count)
+		assert.False(t, recovered)
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		count := 0
+		for attempt := range Attempts(ctx, WithTimes(5)) {
+			count++
+			cancel()
+			attempt.Fail(testErr)
+		}
+		assert.Equal(t, 1, count)
+		assert.Equal(t, context.Canceled, ctx.Err())
+	})
+
+	t.Run("attempt context", func(t *testing.T) {
+		for attempt := range Attempts(context.Background(), WithAttemptTimeout(time.Second)) {
+			_, ok := attempt.Context().Deadline()
+			assert.True(t, ok)
+		}
+	})
+}
+
+func TestClassifier(t *testing.T) {
+	errFatal := errors.New("fatal")
+	errSlow := errors.New("slow")
+	classifier := func(err error) (bool, time.Duration, bool) {
+		switch {
+		case errors.Is(err, errFatal):
+			return false, 0, false
+		case errors.Is(err, errSlow):
+			return true, 30 * time.Millisecond, true
+		default:
+			return true, 0, false
+		}
+	}
+	tests := []struct {
+		name     string
+		errs     []error
+		opts     []Option
+		attempts int
+		total    time.Duration
+		err      error
+	}{
+		{name: "fatal", errs: []error{testErr, errFatal, testErr}, attempts: 2, total: 10 * time.Millisecond, err: errFatal},
+		{name: "classified delay", errs: []error{errSlow, testErr, errSlow}, attempts: 4, total: 30*time.Millisecond + 10*time.Millisecond + 30*time.Millisecond},
+		{name: "max delay", errs: []error{errSlow}, opts: []Option{WithMaxDelay(20 * time.Millisecond)}, attempts: 2, total: 20 * time.Millisecond},
+		{name: "retry if ignored", errs: []error{testErr}, opts: []Option{WithRetryIf(func(error) bool { return false })}, attempts: 2, total: 10 * time.Millisecond},
+		{name: "exhausted", errs: []error{testErr, testErr, testErr, testErr}, attempts: 4, total: 30 * time.Millisecond, err: testErr},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count := 0
+			result := DoResult(context.Background(), func() error {
+				count++
+				if count <= len(test.errs) {
+					return test.errs[count-1]
+				}
+				return nil
+			}, append([]Option{WithTimes(3), WithDelayStrategy(FixedDelay(10 * time.Millisecond)), WithClassifier(classifier)}, test.opts...)...)
+			assert.Equal(t, test.attempts, count)
+			assert.Equal(t, test.total, result.TotalDelay)
+			assert.Equal(t, test.err, result.Err)
+		})
+	}
+}
+
+func TestContinueIf(t *testing.T) {
+	t.Run("toggled mid loop", func(t *testing.T) {
+		enabled := true
+		var calls []string
+		count := 0
+		err := Do(context.Background(), func() error {
+			count++
+			if count == 2 {
+				enabled = false
+			}
+			return testErr
+		},
+			WithTimes(5),
+			WithOnFailedFunc(func(n int, err error) { calls = append(calls, "failed") }),
+			WithContinueIf(func() bool {
+				calls = append(calls, "continue")
+				return enabled
+			}),
+		)
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 2, count)
+		assert.Equal(t, []string{"failed", "continue", "failed", "continue"}, calls)
+	})
+
+	t.Run("not called when retry if stops", func(t *testing.T) {
+		called := false
+		err := Do(context.Background(), func() error { return testErr },
+			WithTimes(5),
+			WithRetryIf(func(error) bool { return false }),
+			WithContinueIf(func() bool {
+				called = true
+				return true
+			}),
+		)
+		assert.Equal(t, testErr, err)
+		assert.False(t, called)
+	})
+}
+
+func TestFibonacciDelayWithReset(t *testing.T) {
+	t.Run("sawtooth", func(t *testing.T) {
+		strategy := FibonacciDelayWithReset(time.Second, 8*time.Second)
+		want := []time.Duration{1, 1, 2, 3, 5, 8, 1, 1, 2, 3, 5, 8, 1}
+		for n, w := range want {
+			assert.Equal(t, w*time.Second, strategy(n, testErr))
+		}
+	})
+
+	t.Run("reset on new do", func(t *testing.T) {
+		strategy := FibonacciDelayWithReset(time.Second, 8*time.Second)
+		assert.Equal(t, time.Second, strategy(0, testErr))
+		assert.Equal(t, time.Second, strategy(1, testErr))
+		assert.Equal(t, 2*time.Second, strategy(2, testErr))
+		assert.Equal(t, time.Second, strategy(0, testErr))
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		maxDelay := time.Duration(math.MaxInt64)
+		strategy := FibonacciDelayWithReset(3, maxDelay)
+		for n := 0; n < 200; n++ {
+			delay := strategy(n, testErr)
+			assert.True(t, delay > 0 && delay <= maxDelay)
+		}
+	})
+
+	t.Run("base above max", func(t *testing.T) {
+		strategy := FibonacciDelayWithReset(10*time.Second, time.Second)
+		assert.Equal(t, time.Second, strategy(0, testErr))
+		assert.Equal(t, time.Second, strategy(1, testErr))
+		assert.Equal(t, time.Second, strategy(2, testErr))
+	})
+}
+
+func TestFibonacciDelayWithJitter(t *testing.T) {
+	t.Run("within jitter range", func(t *testing.T) {
+		fibonacci := FibonacciDelay(100*time.Millisecond, time.Minute)
+		strategy := FibonacciDelayWithJitter(100*time.Millisecond, time.Minute, 0.2)
+		for n := 0; n < 10; n++ {
+			delay := strategy(n, testErr)
+			expected := fibonacci(n, testErr)
+			assert.GreaterOrEqual(t, delay, expected*8/10)
+			assert.LessOrEqual(t, delay, expected*12/10)
+		}
+	})
+
+	t.Run("zero jitter equals FibonacciDelay", func(t *testing.T) {
+		fibonacci := FibonacciDelay(100*time.Millisecond, time.Minute)
+		strategy := FibonacciDelayWithJitter(100*time.Millisecond, time.Minute, 0)
+		for n := 0; n < 20; n++ {
+			assert.Equal(t, fibonacci(n, testErr), strategy(n, testErr))
+		}
+	})
+}
+
+func TestOnContextCancel(t *testing.T) {
+	type call struct {
+		n       int
+		lastErr error
+	}
+
+	t.Run("canceled during delay", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var calls []call
+		err := Do(ctx, func() error {
+			cancel()
+			return testErr
+		},
+			WithTimes(3),
+			WithDelayStrategy(FixedDelay(time.Hour)),
+			WithOnContextCancelFunc(func(n int, lastErr error) { calls = append(calls, call{n, lastErr}) }),
+		)
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, []call{{1, testErr}}, calls)
+	})
+
+	t.Run("canceled before start", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		var calls []call
+		err := Do(ctx, func() error { return nil },
+			WithOnContextCancelFunc(func(n int, lastErr error) { calls = append(calls, call{n, lastErr}) }),
+		)
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, []call{{0, nil}}, calls)
+	})
+
+	t.Run("canceled while waiting for rate limiter", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var calls []call
+		err := Do(ctx, func() error { return testErr },
+			WithTimes(3),
+			WithRateLimiter(&cancelLimiter{cancel: cancel}),
+			WithContextErrorWrapping(),
+			WithOnContextCancelFunc(func(n int, lastErr error) { calls = append(calls, call{n, lastErr}) }),
+		)
+		assert.ErrorIs(t, err, context.Canceled)
+		var ctxErr *ContextError
+		assert.ErrorAs(t, err, &ctxErr)
+		assert.Equal(t, 1, ctxErr.Attempts())
+		assert.Equal(t, testErr, ctxErr.LastErr)
+		assert.Equal(t, []call{{1, testErr}}, calls)
+	})
+
+	t.Run("canceled while waiting for consecutive success", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var calls []call
+		err := Do(ctx, func() error {
+			cancel()
+			return nil
+		},
+			WithTimes(3),
+			WithUntilConsecutiveSuccess(2),
+			WithDelayStrategy(FixedDelay(time.Hour)),
+			WithContextErrorWrapping(),
+			WithOnContextCancelFunc(func(n int, lastErr error) { calls = append(calls, call{n, lastErr}) }),
+		)
+		assert.ErrorIs(t, err, context.Canceled)
+		var ctxErr *ContextError
+		assert.ErrorAs(t, err, &ctxErr)
+		assert.Equal(t, 1, ctxErr.Attempts())
+		assert.Equal(t, []call{{1, nil}}, calls)
+	})
+
+	tests := []struct {
+		name string
+		fn   func() error
+		opts []Option
+	}{
+		{name: "success", fn: func() error { return nil }},
+		{name: "exhausted", fn: func() error { return testErr }},
+		{name: "stopped", fn: func() error { return testErr }, opts: []Option{WithStopChannel(closedChan())}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			called := false
+			Do(context.Background(), test.fn, append([]Option{
+				WithTimes(2),
+				WithDelayStrategy(FixedDelay(time.Millisecond)),
+				WithOnContextCancelFunc(func(n int, lastErr error) { called = true }),
+			}, test.opts...)...)
+			assert.False(t, called)
+		})
+	}
+}
+
+func closedChan() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+func TestSharedAttemptLimit(t *testing.T) {
+	t.Run("nested", func(t *testing.T) {
+		counter := NewAttemptCounter(10)
+		calls := 0
+		err := Do(context.Background(), func() error {
+			return Do(context.Background(), func() error {
+				calls++
+				return testErr
+			}, WithTimes(5), WithSharedAttemptLimit(counter))
+		}, WithTimes(5), WithSharedAttemptLimit(counter))
+		assert.ErrorIs(t, err, testErr)
+		// 外层每次执行占用一次, 内层共执行10-outer次
+		assert.Equal(t, 10, counter.Count())
+		assert.Less(t, calls, 10)
+	})
+
+	t.Run("limit reached before first attempt", func(t *testing.T) {
+		counter := NewAttemptCounter(1)
+		assert.True(t, counter.Acquire())
+		called := false
+		err := Do(context.Background(), func() error {
+			called = true
+			return nil
+		}, WithSharedAttemptLimit(counter))
+		assert.Equal(t, ErrAttemptLimit, err)
+		assert.False(t, called)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		counter := NewAttemptCounter(50)
+		var calls atomic.Int64
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				Do(context.Background(), func() error {
+					calls.Add(1)
+					return testErr
+				}, WithTimes(10), WithSharedAttemptLimit(counter))
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int64(50), calls.Load())
+		assert.Equal(t, 50, counter.Count())
+	})
+}
+
+func TestUncappedDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		uncapped DelayStrategy
+		capped   DelayStrategy
+	}{
+		{name: "exponential", uncapped: ExponentialDelayUncapped(time.Millisecond), capped: ExponentialDelay(time.Millisecond, time.Minute)},
+		{name: "linear", uncapped: LinearDelayUncapped(time.Second), capped: LinearDelay(time.Second, time.Minute)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewConfig(WithDelayStrategy(test.uncapped), WithMaxDelay(time.Minute))
+			for n := 0; n < 62; n++ {
+				assert.Equal(t, test.capped(n, testErr), config.clampDelay(test.uncapped(n, testErr)))
+			}
+		})
+	}
+
+	t.Run("same total delay", func(t *testing.T) {
+		run := func(opts ...Option) time.Duration {
+			return DoResult(context.Background(), func() error { return testErr }, append(opts, WithTimes(20))...).TotalDelay
+		}
+		assert.Equal(t,
+			run(WithDelayStrategy(ExponentialDelay(1, 100))),
+			run(WithDelayStrategy(ExponentialDelayUncapped(1)), WithMaxDelay(100)),
+		)
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		maxDuration := time.Duration(math.MaxInt64)
+		assert.Equal(t, maxDuration, ExponentialDelayUncapped(time.Second)(40, testErr))
+		assert.Equal(t, maxDuration, ExponentialDelayUncapped(1)(63, testErr))
+		assert.Equal(t, maxDuration, ExponentialDelayUncapped(1)(1000, testErr))
+		assert.Equal(t, time.Duration(1<<62), ExponentialDelayUncapped(1)(62, testErr))
+		assert.Equal(t, maxDuration, LinearDelayUncapped(time.Hour)(math.MaxInt-1, testErr))
+	})
+}
+
+func TestOnAttemptDuration(t *testing.T) {
+	t.Run("measured", func(t *testing.T) {
+		var durations []time.Duration
+		var errs []error
+		count := 0
+		err := Do(context.Background(), func() error {
+			count++
+			if count == 1 {
+				time.Sleep(20 * time.Millisecond)
+				return testErr
+			}
+			return nil
+		},
+			WithTimes(2),
+			WithDelayStrategy(FixedDelay(200*time.Millisecond)),
+			WithOnAttemptDuration(func(n int, d time.Duration, err error) {
+				durations = append(durations, d)
+				errs = append(errs, err)
+			}),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, []error{testErr, nil}, errs)
+		assert.GreaterOrEqual(t, durations[0], 20*time.Millisecond)
+		assert.Less(t, durations[0], 200*time.Millisecond)
+		assert.Less(t, durations[1], 20*time.Millisecond)
+	})
+
+	t.Run("fake clock", func(t *testing.T) {
+		clock := retrytest.NewFakeClock(time.Now())
+		var got time.Duration
+		Do(context.Background(), func() error {
+			clock.Advance(3 * time.Second)
+			return nil
+		}, WithClock(clock), WithOnAttemptDuration(func(n int, d time.Duration, err error) { got = d }))
+		assert.Equal(t, 3*time.Second, got)
+	})
+}
+
+func TestPerErrorLimit(t *testing.T) {
+	errConn := errors.New("conn")
+	errTimeout := errors.New("timeout")
+	isConn := func(err error) bool { return errors.Is(err, errConn) }
+	isTimeout := func(err error) bool { return errors.Is(err, errTimeout) }
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		err      error
+	}{
+		{name: "conn limit", errs: []error{errConn, errTimeout, errConn, errTimeout, errConn}, attempts: 5, err: errConn},
+		{name: "timeout limit", errs: []error{errTimeout, errConn, errTimeout, errTimeout, errTimeout}, attempts: 5, err: errTimeout},
+		{name: "interleaved within limits", errs: []error{errConn, errTimeout, errConn, errTimeout, nil}, attempts: 5},
+		{name: "unmatched uses global limit", errs: []error{testErr, testErr, testErr, testErr, testErr, testErr, testErr}, attempts: 6, err: testErr},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count := 0
+			err := Do(context.Background(), func() error {
+				count++
+				return test.errs[count-1]
+			},
+				WithTimes(5),
+				WithPerErrorLimit(isConn, 2),
+				WithPerErrorLimit(isTimeout, 3),
+			)
+			assert.Equal(t, test.attempts, count)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestSpinThreshold(t *testing.T) {
+	t.Run("spin delay", func(t *testing.T) {
+		start := time.Now()
+		result := DoResult(context.Background(), func() error { return testErr },
+			WithTimes(10),
+			WithDelayStrategy(FixedDelay(100*time.Microsecond)),
+			WithSpinThreshold(time.Millisecond),
+		)
+		assert.Equal(t, time.Millisecond, result.TotalDelay)
+		assert.GreaterOrEqual(t, time.Since(start), time.Millisecond)
+	})
+
+	t.Run("canceled while spinning", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var remaining time.Duration
+		err := Do(ctx, func() error {
+			time.AfterFunc(10*time.Millisecond, cancel)
+			return testErr
+		},
+			WithTimes(1),
+			WithDelayStrategy(FixedDelay(time.Hour)),
+			WithSpinThreshold(2*time.Hour),
+			WithOnDelayInterrupted(func(r time.Duration) { remaining = r }),
+		)
+		assert.Equal(t, context.Canceled, err)
+		assert.Greater(t, remaining, time.Duration(0))
+	})
+
+	t.Run("stopped while spinning", func(t *testing.T) {
+		stop := make(chan struct{})
+		err := Do(context.Background(), func() error {
+			time.AfterFunc(10*time.Millisecond, func() { close(stop) })
+			return testErr
+		},
+			WithTimes(1),
+			WithDelayStrategy(FixedDelay(time.Hour)),
+			WithSpinThreshold(2*time.Hour),
+			WithStopChannel(stop),
+		)
+		assert.Equal(t, ErrStopped, err)
+	})
+}
+
+func BenchmarkSleepAccuracy(b *testing.B) {
+	const delay = 100 * time.Microsecond
+	for _, threshold := range []time.Duration{0, time.Millisecond} {
+		name := "timer"
+		if threshold > 0 {
+			name = "spin"
+		}
+		b.Run(name, func(b *testing.B) {
+			s := &sleeper{spinThreshold: threshold}
+			defer s.stop()
+			var overshoot time.Duration
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				_ = s.sleep(context.Background(), delay)
+				overshoot += time.Since(start) - delay
+			}
+			b.ReportMetric(float64(overshoot)/float64(b.N), "overshoot-ns/op")
+		})
+	}
+}
+
+func TestEvenlyBudgetedAttempts(t *testing.T) {
+	t.Run("shrinks as time passes", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		var timeouts []time.Duration
+		err := DoCtx(ctx, func(ctx context.Context) error {
+			deadline, ok := ctx.Deadline()
+			assert.True(t, ok)
+			timeouts = append(timeouts, time.Until(deadline))
+			if len(timeouts) == 1 {
+				// 首次执行忽略超时, 耗尽自己的时间片后继续占用
+				time.Sleep(800 * time.Millisecond)
+			}
+			if len(timeouts) < 3 {
+				return testErr
+			}
+			return nil
+		}, WithTimes(3), WithEvenlyBudgetedAttempts())
+		assert.Nil(t, err)
+		assert.Len(t, timeouts, 3)
+		assert.InDelta(t, 500*time.Millisecond, timeouts[0], float64(100*time.Millisecond))
+		assert.InDelta(t, 400*time.Millisecond, timeouts[1], float64(100*time.Millisecond))
+		assert.InDelta(t, 600*time.Millisecond, timeouts[2], float64(100*time.Millisecond))
+	})
+
+	t.Run("attempt timeout smaller", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+		defer cancel()
+		var timeout time.Duration
+		DoCtx(ctx, func(ctx context.Context) error {
+			deadline, _ := ctx.Deadline()
+			timeout = time.Until(deadline)
+			return nil
+		}, WithTimes(3), WithEvenlyBudgetedAttempts(), WithAttemptTimeout(time.Second))
+		assert.InDelta(t, time.Second, timeout, float64(100*time.Millisecond))
+	})
+
+	t.Run("no deadline", func(t *testing.T) {
+		DoCtx(context.Background(), func(ctx context.Context) error {
+			_, ok := ctx.Deadline()
+			assert.False(t, ok)
+			return nil
+		}, WithTimes(3), WithEvenlyBudgetedAttempts())
+	})
+}
+
+func TestPauseChannel(t *testing.T) {
+	t.Run("pause and resume", func(t *testing.T) {
+		pause := make(chan bool, 1)
+		var count atomic.Int32
+		done := make(chan error)
+		go func() {
+			done <- Do(context.Background(), func() error {
+				if count.Add(1) == 1 {
+					pause <- true
+					return testErr
+				}
+				return nil
+			}, WithTimes(3), WithPauseChannel(pause))
+		}()
+		select {
+		case <-done:
+			t.Fatal("retried while paused")
+		case <-time.After(50 * time.Millisecond):
+		}
+		assert.Equal(t, int32(1), count.Load())
+		pause <- false
+		assert.Nil(t, <-done)
+		assert.Equal(t, int32(2), count.Load())
+	})
+
+	t.Run("paused and resumed during delay", func(t *testing.T) {
+		pause := make(chan bool)
+		done := make(chan Result)
+		go func() {
+			done <- DoResult(context.Background(), func() error { return testErr },
+				WithTimes(1), WithDelayStrategy(FixedDelay(100*time.Millisecond)), WithPauseChannel(pause))
+		}()
+		pause <- true
+		pause <- false
+		result := <-done
+		assert.Equal(t, 2, result.Attempts)
+		assert.Equal(t, testErr, result.Err)
+	})
+
+	t.Run("send while fn runs", func(t *testing.T) {
+		pause := make(chan bool)
+		var count atomic.Int32
+		done := make(chan error)
+		go func() {
+			done <- Do(context.Background(), func() error {
+				if count.Add(1) == 1 {
+					pause <- true
+					return testErr
+				}
+				return nil
+			}, WithTimes(3), WithPauseChannel(pause))
+		}()
+		select {
+		case <-done:
+			t.Fatal("retried while paused")
+		case <-time.After(50 * time.Millisecond):
+		}
+		pause <- false
+		assert.Nil(t, <-done)
+		assert.Equal(t, int32(2), count.Load())
+	})
+
+	t.Run("closed while fn runs", func(t *testing.T) {
+		pause := make(chan bool)
+		attempts, err := DoN(context.Background(), func() error {
+			if pause != nil {
+				pause <- true
+				close(pause)
+				pause = nil
+				return testErr
+			}
+			return nil
+		}, WithTimes(3), WithPauseChannel(pause))
+		assert.Nil(t, err)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("closed channel resumes", func(t *testing.T) {
+		pause := make(chan bool, 1)
+		attempts, err := DoN(context.Background(), func() error {
+			if pause != nil {
+				pause <- true
+				close(pause)
+				pause = nil
+				return testErr
+			}
+			return nil
+		}, WithTimes(3), WithPauseChannel(pause))
+		assert.Nil(t, err)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("canceled while paused", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		pause := make(chan bool, 1)
+		pause <- true
+		time.AfterFunc(20*time.Millisecond, cancel)
+		attempts, err := DoN(ctx, func() error { return testErr }, WithTimes(3), WithPauseChannel(pause))
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("stopped while paused", func(t *testing.T) {
+		pause := make(chan bool, 1)
+		pause <- true
+		stop := make(chan struct{})
+		time.AfterFunc(20*time.Millisecond, func() { close(stop) })
+		err := Do(context.Background(), func() error { return testErr }, WithTimes(3), WithPauseChannel(pause), WithStopChannel(stop))
+		assert.Equal(t, ErrStopped, err)
+	})
+}
+
+func TestSimulateDelays(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy DelayStrategy
+		times    int
+		want     []time.Duration
+	}{
+		{name: "exponential", strategy: ExponentialDelay(100*time.Millisecond, time.Second), times: 5, want: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}},
+		{name: "stop delay", strategy: ScheduleDelay(1, 2, StopDelay), times: 5, want: []time.Duration{1, 2}},
+		{name: "zero times", strategy: FixedDelay(time.Second), times: 0, want: []time.Duration{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, SimulateDelays(test.strategy, test.times))
+		})
+	}
+
+	t.Run("stateful strategy", func(t *testing.T) {
+		strategy := ExponentialDelayWithReset(time.Second, 4*time.Second)
+		first := SimulateDelays(strategy, 6)
+		assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, time.Second, 2 * time.Second, 4 * time.Second}, first)
+		assert.Equal(t, first, SimulateDelays(strategy, 6))
+	})
+}
+
+func TestDoRace(t *testing.T) {
+	t.Run("first success cancels others", func(t *testing.T) {
+		var started atomic.Int32
+		var canceled atomic.Int32
+		var wg sync.WaitGroup
+		wg.Add(2)
+		data, err := DoRace(context.Background(), func(ctx context.Context) (int, error) {
+			i := started.Add(1)
+			if i == 1 {
+				time.Sleep(20 * time.Millisecond)
+				return 42, nil
+			}
+			if i <= 3 {
+				<-ctx.Done()
+				canceled.Add(1)
+				wg.Done()
+			}
+			return 0, ctx.Err()
+		}, 3, WithTimes(3))
+		assert.Nil(t, err)
+		assert.Equal(t, 42, data)
+		wg.Wait()
+		assert.Equal(t, int32(2), canceled.Load())
+	})
+
+	t.Run("success after retry", func(t *testing.T) {
+		var count atomic.Int32
+		data, err := DoRace(context.Background(), func(ctx context.Context) (string, error) {
+			if count.Add(1) <= 4 {
+				return "", testErr
+			}
+			return "ok", nil
+		}, 2, WithTimes(5))
+		assert.Nil(t, err)
+		assert.Equal(t, "ok", data)
+	})
+
+	t.Run("all failed", func(t *testing.T) {
+		var count atomic.Int32
+		data, err := DoRace(context.Background(), func(ctx context.Context) (int, error) {
+			count.Add(1)
+			return 1, testErr
+		}, 3, WithTimes(2))
+		assert.Equal(t, 0, data)
+		assert.ErrorIs(t, err, testErr)
+		assert.Len(t, err.(interface{ Unwrap() []error }).Unwrap(), 3)
+		assert.Equal(t, int32(9), count.Load())
+	})
+
+	t.Run("nil func", func(t *testing.T) {
+		_, err := DoRace[int](context.Background(), nil, 3)
+		assert.Equal(t, ErrNilFunc, err)
+	})
+}
+
+func TestAdaptiveDelay(t *testing.T) {
+	t.Run("grows during failure burst", func(t *testing.T) {
+		adaptive := NewAdaptiveDelay(0.5, 100*time.Millisecond, 10*time.Second)
+		prev := time.Duration(0)
+		for n := 0; n < 10; n++ {
+			delay := adaptive.Delay(n, testErr)
+			assert.Greater(t, delay, prev)
+			assert.LessOrEqual(t, delay, 10*time.Second)
+			prev = delay
+		}
+		assert.InDelta(t, 1, adaptive.Rate(), 0.01)
+	})
+
+	t.Run("shrinks after recovery", func(t *testing.T) {
+		adaptive := NewAdaptiveDelay(0.5, 100*time.Millisecond, 10*time.Second)
+		for n := 0; n < 5; n++ {
+			adaptive.Delay(n, testErr)
+		}
+		high := adaptive.Delay(5, testErr)
+		for i := 0; i < 5; i++ {
+			adaptive.Success()
+		}
+		low := adaptive.Delay(0, testErr)
+		assert.Less(t, low, high)
+	})
+
+	t.Run("bounds", func(t *testing.T) {
+		adaptive := NewAdaptiveDelay(1, time.Second, 2*time.Second)
+		assert.Equal(t, 2*time.Second, adaptive.Delay(0, testErr))
+		assert.Equal(t, 2*time.Second, adaptive.Delay(1, nil))
+		adaptive.Success()
+		assert.Equal(t, time.Second, adaptive.Delay(2, nil))
+	})
+
+	t.Run("with do", func(t *testing.T) {
+		adaptive := NewAdaptiveDelay(0.5, 0, 8*time.Millisecond)
+		count := 0
+		result := DoResult(context.Background(), func() error {
+			count++
+			if count < 4 {
+				return testErr
+			}
+			return nil
+		},
+			WithTimes(5),
+			WithDelayStrategy(adaptive.Delay),
+			WithOnSuccessFunc(func(n int) { adaptive.Success() }),
+		)
+		assert.Nil(t, result.Err)
+		assert.Equal(t, 4*time.Millisecond+6*time.Millisecond+7*time.Millisecond, result.TotalDelay)
+		assert.Equal(t, 0.4375, adaptive.Rate())
+	})
+
+	t.Run("simulate", func(t *testing.T) {
+		adaptive := NewAdaptiveDelay(0.5, 0, 8*time.Millisecond)
+		assert.Equal(t, []time.Duration{4 * time.Millisecond, 6 * time.Millisecond, 7 * time.Millisecond}, adaptive.Simulate(3))
+		assert.Equal(t, []time.Duration{0, 0}, SimulateDelays(adaptive.Delay, 2))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+		defer cancel()
+		assert.Equal(t, 4, EstimateAttempts(ctx, adaptive.Delay, 3))
+		assert.Equal(t, float64(0), adaptive.Rate())
+	})
+}
+
+func TestContextDelayStrategy(t *testing.T) {
+	t.Run("reads deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+		defer cancel()
+		var remaining []time.Duration
+		Do(ctx, func() error { return testErr },
+			WithTimes(2),
+			WithDelayStrategy(FixedDelay(time.Hour)),
+			WithContextDelayStrategy(func(ctx context.Context, n int, err error) time.Duration {
+				deadline, ok := ctx.Deadline()
+				assert.True(t, ok)
+				remaining = append(remaining, time.Until(deadline))
+				return 0
+			}),
+		)
+		assert.Len(t, remaining, 2)
+		for _, r := range remaining {
+			assert.InDelta(t, time.Hour, r, float64(time.Minute))
+		}
+	})
+
+	t.Run("adapted strategy", func(t *testing.T) {
+		result := DoResult(context.Background(), func() error { return testErr },
+			WithTimes(3),
+			WithContextDelayStrategy(AdaptContextDelayStrategy(LinearDelay(time.Millisecond, time.Second))),
+			WithMaxDelay(2*time.Millisecond),
+		)
+		assert.Equal(t, time.Millisecond+2*time.Millisecond+2*time.Millisecond, result.TotalDelay)
+	})
+}
+
+func TestStartupJitter(t *testing.T) {
+	t.Run("within bounds", func(t *testing.T) {
+		const maxJitter = 50 * time.Millisecond
+		expected := time.Duration(rand.New(rand.NewSource(1)).Int63n(int64(maxJitter)))
+		start := time.Now()
+		var firstAttempt time.Duration
+		result := DoResult(context.Background(), func() error {
+			firstAttempt = time.Since(start)
+			return nil
+		}, WithStartupJitter(maxJitter), WithRandSource(rand.New(rand.NewSource(1))))
+		assert.Nil(t, result.Err)
+		assert.Equal(t, expected, result.TotalDelay)
+		assert.GreaterOrEqual(t, firstAttempt, expected)
+		assert.Less(t, firstAttempt, maxJitter+50*time.Millisecond)
+	})
+
+	t.Run("random", func(t *testing.T) {
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 5; i++ {
+			result := DoResult(context.Background(), func() error { return nil }, WithStartupJitter(time.Millisecond))
+			assert.Less(t, result.TotalDelay, time.Millisecond)
+			seen[result.TotalDelay] = true
+		}
+		assert.Greater(t, len(seen), 1)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		called := false
+		err := Do(ctx, func() error {
+			called = true
+			return nil
+		}, WithStartupJitter(time.Hour))
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.False(t, called)
+	})
+}
+
+func TestAbortIf(t *testing.T) {
+	errServer := errors.New("server")
+	firstAllServer := func(history []error) bool {
+		if len(history) < 3 {
+			return false
+		}
+		for _, err := range history[:3] {
+			if !errors.Is(err, errServer) {
+				return false
+			}
+		}
+		return true
+	}
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		err      error
+	}{
+		{name: "systemic", errs: []error{errServer, errServer, errServer, nil}, attempts: 3, err: errServer},
+		{name: "transient", errs: []error{errServer, testErr, errServer, errServer, nil}, attempts: 5},
+		{name: "exhausted", errs: []error{testErr, testErr, testErr, testErr, testErr, testErr}, attempts: 6, err: testErr},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count := 0
+			attempts, err := DoN(context.Background(), func() error {
+				count++
+				return test.errs[count-1]
+			}, WithTimes(5), WithAbortIf(func(history []error) bool {
+				assert.Equal(t, test.errs[:len(history)], history)
+				return firstAllServer(history)
+			}))
+			assert.Equal(t, test.attempts, attempts)
+			assert.Equal(t, test.err, err)
+		})
+	}
+
+	t.Run("not combined", func(t *testing.T) {
+		err := Do(context.Background(), func() error { return testErr }, WithTimes(2), WithAbortIf(func([]error) bool { return false }))
+		assert.Equal(t, testErr, err)
+	})
+}
+
+func TestDoWithDataN(t *testing.T) {
+	t.Run("success after retry", func(t *testing.T) {
+		exec := 0
+		data, attempts, err := DoWithDataN(context.Background(), func() (string, error) {
+			exec++
+			if exec < 3 {
+				return "partial", testErr
+			}
+			return "ok", nil
+		}, WithTimes(5))
+		assert.Nil(t, err)
+		assert.Equal(t, "ok", data)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("all failed", func(t *testing.T) {
+		data, attempts, err := DoWithDataN(context.Background(), func() (int, error) { return 1, testErr }, WithTimes(5))
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 0, data)
+		assert.Equal(t, 6, attempts)
+	})
+
+	t.Run("combined errors end with real error", func(t *testing.T) {
+		exec := 0
+		data, attempts, err := DoWithDataN(context.Background(), func() (string, error) {
+			exec++
+			if exec == 1 {
+				return "pending", nil
+			}
+			return "partial", testErr
+		}, WithTimes(1), WithCombineErrors(), WithRetryOnResult(func(s string) bool { return s == "pending" }))
+		assert.ErrorIs(t, err, testErr)
+		assert.ErrorIs(t, err, ErrRetryResult)
+		assert.Equal(t, "", data)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("break", func(t *testing.T) {
+		data, attempts, err := DoWithDataN(context.Background(), func() (int, error) { return 1, Break(testErr) }, WithTimes(5))
+		assert.Equal(t, testErr, err)
+		assert.Equal(t, 1, data)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		data, attempts, err := DoWithDataN(ctx, func() (int, error) { return 1, nil }, WithTimes(5))
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, 0, data)
+		assert.Equal(t, 0, attempts)
+	})
+
+	t.Run("same as DoN", func(t *testing.T) {
+		fn := func() error { return testErr }
+		wantAttempts, wantErr := DoN(context.Background(), fn, WithTimes(3))
+		_, attempts, err := DoWithDataN(context.Background(), func() (int, error) { return 0, fn() }, WithTimes(3))
+		assert.Equal(t, wantAttempts, attempts)
+		assert.Equal(t, wantErr, err)
+	})
 }
@@ -0,0 +1,81 @@
+package retry
+
+import (
+	"context"
+	"time"
+)
+
+// Retryer 链式构建配置, 与Option等价, 适合定义一次后在多处复用的重试策略.
+// 每个链式方法都返回新的Retryer, 原Retryer不受影响, 可以在多处并发地基于同一Retryer派生
+type Retryer struct {
+	opts []Option
+}
+
+func NewRetryer() *Retryer {
+	return &Retryer{}
+}
+
+// With 返回追加了配置选项的新Retryer
+func (r *Retryer) With(opts ...Option) *Retryer {
+	return &Retryer{opts: append(r.opts[:len(r.opts):len(r.opts)], opts...)}
+}
+
+// Times 同WithTimes
+func (r *Retryer) Times(retryTimes int) *Retryer {
+	return r.With(WithTimes(retryTimes))
+}
+
+// Delay 同WithDelayStrategy
+func (r *Retryer) Delay(delayType DelayStrategy) *Retryer {
+	return r.With(WithDelayStrategy(delayType))
+}
+
+// MinDelay 同WithMinDelay
+func (r *Retryer) MinDelay(minDelay time.Duration) *Retryer {
+	return r.With(WithMinDelay(minDelay))
+}
+
+// MaxDelay 同WithMaxDelay
+func (r *Retryer) MaxDelay(maxDelay time.Duration) *Retryer {
+	return r.With(WithMaxDelay(maxDelay))
+}
+
+// MaxElapsedTime 同WithMaxElapsedTime
+func (r *Retryer) MaxElapsedTime(maxElapsedTime time.Duration) *Retryer {
+	return r.With(WithMaxElapsedTime(maxElapsedTime))
+}
+
+// AttemptTimeout 同WithAttemptTimeout
+func (r *Retryer) AttemptTimeout(attemptTimeout time.Duration) *Retryer {
+	return r.With(WithAttemptTimeout(attemptTimeout))
+}
+
+// RetryIf 同WithRetryIf
+func (r *Retryer) RetryIf(fn RetryIfFunc) *Retryer {
+	return r.With(WithRetryIf(fn))
+}
+
+// OnRetry 同WithOnRetryFunc
+func (r *Retryer) OnRetry(fn OnRetryFunc) *Retryer {
+	return r.With(WithOnRetryFunc(fn))
+}
+
+// OnFailed 同WithOnFailedFunc
+func (r *Retryer) OnFailed(fn OnFailedFunc) *Retryer {
+	return r.With(WithOnFailedFunc(fn))
+}
+
+// OnSuccess 同WithOnSuccessFunc
+func (r *Retryer) OnSuccess(fn OnSuccessFunc) *Retryer {
+	return r.With(WithOnSuccessFunc(fn))
+}
+
+// Build 根据已设置的配置选项创建配置
+func (r *Retryer) Build() *Config {
+	return NewConfig(r.opts...)
+}
+
+// Do 使用已设置的配置选项执行fn并在失败时重试
+func (r *Retryer) Do(ctx context.Context, fn func() error) error {
+	return r.Build().Do(ctx, fn)
+}
@@ -0,0 +1,68 @@
+// Package retrytest 提供测试重试逻辑的辅助工具
+package retrytest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type waiter struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// FakeClock 手动推进的时钟, 实现了retry.Clock, 并发安全
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []waiter
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After 返回在时钟推进d后收到当前时间的通道, d<=0时立即收到
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, waiter{until: c.now.Add(d), ch: ch})
+	sort.Slice(c.waiters, func(i, j int) bool { return c.waiters[i].until.Before(c.waiters[j].until) })
+	c.cond.Broadcast()
+	return ch
+}
+
+// Advance 将时钟推进d, 并通知所有到期的After
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for len(c.waiters) > 0 && !c.waiters[0].until.After(c.now) {
+		c.waiters[0].ch <- c.now
+		c.waiters = c.waiters[1:]
+	}
+}
+
+// BlockUntil 阻塞直到有n个After在等待
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
@@ -0,0 +1,16 @@
+package retry
+
+import "context"
+
+// Tracer 链路追踪, 可基于OpenTelemetry等实现. 整个重试过程和每次执行分别对应一个span
+type Tracer interface {
+	// StartSpan 创建名为name的span并返回包含该span的ctx, 调用返回的end结束span, err为对应的错误
+	StartSpan(ctx context.Context, name string) (context.Context, func(err error))
+}
+
+// NopTracer 不做任何处理的Tracer
+type NopTracer struct{}
+
+func (NopTracer) StartSpan(ctx context.Context, name string) (context.Context, func(err error)) {
+	return ctx, func(err error) {}
+}